@@ -0,0 +1,24 @@
+//Package blockedPolicy exposes an enumerable that represents what a publisher does while RabbitMQ has blocked its connection.
+//The purpose of this package is to simply the user experience of the user when setting up their configuration for connection to RabbitMQ.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package blockedPolicy
+
+//BlockedPolicy defines what happens to a publish while RabbitMQ has blocked the publishing connection.
+//		RabbitMQ blocks connections that publish when it raises a memory or disk alarm, and unblocks them once the alarm clears.
+//		While a connection is blocked, RabbitMQ stops reading from it, so a publish would otherwise hang without any indication why.
+//Default blockedPolicy is wait
+type BlockedPolicy int
+
+const (
+	//Wait holds the publish until RabbitMQ unblocks the connection, or the context passed to PublishWithContext is done.
+	Wait BlockedPolicy = iota
+
+	//FailFast fails the publish straight away with ErrConnectionBlocked, so that the caller can shed load or fall back.
+	FailFast
+)
+
+func (blockedPolicy BlockedPolicy) String() string {
+	return [...]string{"wait", "failFast"}[blockedPolicy]
+}
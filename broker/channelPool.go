@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+//pooledChannel is a channel that messages are published on, along with the confirm tracker for it if the publisher is in confirm mode.
+type pooledChannel struct {
+	channel    *amqp.Channel
+	connection *amqp.Connection
+	tracker    *confirmTracker
+	closed     <-chan *amqp.Error
+}
+
+//isClosed returns whether the channel has been closed, for example by a channel exception caused by a bad publish.
+func (leased *pooledChannel) isClosed() bool {
+	select {
+	case <-leased.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+//close closes the channel once it has been discarded by the pool. Closing a channel whose connection is already closed does nothing.
+func (leased *pooledChannel) close() {
+	if leased.channel != nil {
+		leased.channel.Close()
+	}
+}
+
+//channelPool leases the channels that messages are published on, so that concurrent publishes never share a channel.
+//		Channels are opened on demand, up to the pool size, on the connection that is open at the time.
+//		When the connection is replaced after a reconnect, the channels opened on the old connection are discarded rather than reused.
+//		A channel that is closed, for example by a channel exception, is discarded when it is returned to the pool, without affecting the other channels.
+type channelPool struct {
+	size       int
+	current    func() (*amqp.Connection, error)
+	open       func(connection *amqp.Connection) (*pooledChannel, error)
+	mutex      sync.Mutex
+	available  *sync.Cond
+	generation *amqp.Connection
+	opened     int
+	idle       []*pooledChannel
+}
+
+//newChannelPool creates a pool of at most size channels. current returns the connection that is open right now, and open opens and sets up a channel on it.
+func newChannelPool(size int, current func() (*amqp.Connection, error), open func(connection *amqp.Connection) (*pooledChannel, error)) *channelPool {
+	pool := channelPool{
+		size:    size,
+		current: current,
+		open:    open,
+	}
+	pool.available = sync.NewCond(&pool.mutex)
+	return &pool
+}
+
+//lease returns a channel that no other publish is using. It blocks until one is released, or the context is done, if all the channels are in use.
+//		The channel must be given back with release once the message has been published.
+//		The current connection is read under the pool's lock, so that the pool only ever moves on to a newer connection, never back to one that has been replaced.
+func (pool *channelPool) lease(ctx context.Context) (*pooledChannel, error) {
+	stopWaking := context.AfterFunc(ctx, func() {
+		pool.mutex.Lock()
+		pool.available.Broadcast()
+		pool.mutex.Unlock()
+	})
+	defer stopWaking()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for {
+		connection, err := pool.current()
+		if err != nil {
+			return nil, err
+		}
+		if connection != pool.generation {
+			pool.reset(connection)
+		}
+
+		for len(pool.idle) > 0 {
+			leased := pool.idle[len(pool.idle)-1]
+			pool.idle = pool.idle[:len(pool.idle)-1]
+			if !leased.isClosed() {
+				return leased, nil
+			}
+			pool.opened--
+		}
+
+		if pool.opened < pool.size {
+			pool.opened++
+			pool.mutex.Unlock()
+			leased, err := pool.open(connection)
+			pool.mutex.Lock()
+			if err != nil {
+				if connection == pool.generation {
+					pool.opened--
+				}
+				pool.available.Signal()
+				return nil, err
+			}
+			if connection != pool.generation {
+				//The connection was replaced while the channel was being opened. Lease a channel on the new connection instead.
+				leased.close()
+				continue
+			}
+			return leased, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pool.available.Wait()
+	}
+}
+
+//release gives a leased channel back to the pool.
+//		Channels that were closed, or that were opened on a connection that has since been replaced, are discarded.
+func (pool *channelPool) release(leased *pooledChannel) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	switch {
+	case leased.connection != pool.generation:
+		//The channel was already discounted when the pool was reset.
+		leased.close()
+	case leased.isClosed():
+		pool.opened--
+	default:
+		pool.idle = append(pool.idle, leased)
+	}
+	pool.available.Signal()
+}
+
+//reset discards every channel opened on the previous connection and closes the ones that are idle.
+//		Channels that are leased are closed when they are released.
+func (pool *channelPool) reset(connection *amqp.Connection) {
+	for _, idle := range pool.idle {
+		idle.close()
+	}
+	pool.generation = connection
+	pool.opened = 0
+	pool.idle = nil
+	pool.available.Broadcast()
+}
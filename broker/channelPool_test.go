@@ -0,0 +1,175 @@
+package broker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/streadway/amqp"
+)
+
+//newTestChannelPool returns a pool whose channels are never really opened. The connection it leases on can be swapped by storing a new one in connection.
+func newTestChannelPool(size int, connection *atomic.Value, opened *int32) *channelPool {
+	return newChannelPool(
+		size,
+		func() (*amqp.Connection, error) {
+			return connection.Load().(*amqp.Connection), nil
+		},
+		func(current *amqp.Connection) (*pooledChannel, error) {
+			atomic.AddInt32(opened, 1)
+			return &pooledChannel{connection: current, closed: make(chan *amqp.Error)}, nil
+		})
+}
+
+func TestLease_GivenReleasedChannel_ShouldReuseChannel(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	connection.Store(&amqp.Connection{})
+	var opened int32
+	pool := newTestChannelPool(2, &connection, &opened)
+
+	// Act
+	first, _ := pool.lease(context.Background())
+	pool.release(first)
+	second, err := pool.lease(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opened))
+}
+
+func TestLease_GivenAllChannelsLeased_ShouldWaitForRelease(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	connection.Store(&amqp.Connection{})
+	var opened int32
+	pool := newTestChannelPool(1, &connection, &opened)
+	first, _ := pool.lease(context.Background())
+
+	// Act
+	leased := make(chan *pooledChannel)
+	go func() {
+		second, _ := pool.lease(context.Background())
+		leased <- second
+	}()
+
+	// Assert
+	select {
+	case <-leased:
+		t.Fatal("a second channel was leased while the only channel was in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.release(first)
+	select {
+	case second := <-leased:
+		assert.Same(t, first, second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released channel was never leased again")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opened))
+}
+
+func TestRelease_GivenClosedChannel_ShouldDiscardChannel(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	connection.Store(&amqp.Connection{})
+	var opened int32
+	pool := newTestChannelPool(1, &connection, &opened)
+	first, _ := pool.lease(context.Background())
+	closed := make(chan *amqp.Error)
+	close(closed)
+	first.closed = closed
+
+	// Act
+	pool.release(first)
+	second, err := pool.lease(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotSame(t, first, second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&opened))
+}
+
+func TestLease_GivenReplacedConnection_ShouldOpenChannelOnNewConnection(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	connection.Store(&amqp.Connection{})
+	var opened int32
+	pool := newTestChannelPool(1, &connection, &opened)
+	first, _ := pool.lease(context.Background())
+	pool.release(first)
+	replacement := &amqp.Connection{}
+	connection.Store(replacement)
+
+	// Act
+	second, err := pool.lease(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotSame(t, first, second)
+	assert.Same(t, replacement, second.connection)
+}
+
+func TestLease_GivenAllChannelsLeasedAndCancelledContext_ShouldReturnContextError(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	connection.Store(&amqp.Connection{})
+	var opened int32
+	pool := newTestChannelPool(1, &connection, &opened)
+	pool.lease(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, err := pool.lease(ctx)
+
+	// Assert
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLease_GivenConnectionReplacedWhileOpeningChannel_ShouldLeaseChannelOnNewConnection(t *testing.T) {
+	// Arrange
+	var connection atomic.Value
+	original := &amqp.Connection{}
+	replacement := &amqp.Connection{}
+	connection.Store(original)
+	opening := make(chan struct{})
+	resume := make(chan struct{})
+	pool := newChannelPool(
+		2,
+		func() (*amqp.Connection, error) {
+			return connection.Load().(*amqp.Connection), nil
+		},
+		func(current *amqp.Connection) (*pooledChannel, error) {
+			if current == original {
+				close(opening)
+				<-resume
+			}
+			return &pooledChannel{connection: current, closed: make(chan *amqp.Error)}, nil
+		})
+
+	// Act
+	leased := make(chan *pooledChannel)
+	go func() {
+		first, _ := pool.lease(context.Background())
+		leased <- first
+	}()
+	<-opening
+	connection.Store(replacement)
+	second, err := pool.lease(context.Background())
+	close(resume)
+	first := <-leased
+	pool.release(second)
+	third, _ := pool.lease(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Same(t, replacement, first.connection)
+	assert.Same(t, replacement, second.connection)
+	assert.Same(t, second, third)
+	assert.Same(t, replacement, pool.generation)
+}
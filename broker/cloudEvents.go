@@ -0,0 +1,237 @@
+package broker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/cloudEventsMode"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	uuid "github.com/satori/go.uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	//cloudEventsContentType is the content type of events published in structured mode.
+	cloudEventsContentType = "application/cloudevents+json"
+	//cloudEventsHeaderPrefix prefixes the headers event attributes are carried in, in binary mode.
+	cloudEventsHeaderPrefix = "cloudEvents_"
+	//legacyCloudEventsHeaderPrefix is the prefix earlier versions of the AMQP protocol binding used. It is understood, but never published.
+	legacyCloudEventsHeaderPrefix = "cloudEvents:"
+	//correlationIdExtension is the extension attribute the CorrelationId of a message is mapped to.
+	correlationIdExtension = "correlationid"
+)
+
+//cloudEvent is a CloudEvent parsed from a consumed message, in either content mode.
+type cloudEvent struct {
+	attributes    models.CloudEvent
+	id            string
+	time          time.Time
+	correlationId string
+	data          []byte
+}
+
+//applyCloudEvent turns the publishing into a CloudEvent in the configured content mode.
+//		It is applied after the publish options, so that the event reflects the final MessageId, Type and ContentType of the message.
+func applyCloudEvent(config models.CloudEventsConfig, publishing *amqp.Publishing) error {
+	if publishing.MessageId == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		publishing.MessageId = id.String()
+	}
+
+	eventType := config.Type
+	if publishing.Type != "" {
+		eventType = publishing.Type
+	}
+	attributes := map[string]interface{}{
+		"specversion": cloudEventsSpecVersion,
+		"id":          publishing.MessageId,
+		"source":      config.Source,
+		"type":        eventType,
+	}
+	if !publishing.Timestamp.IsZero() {
+		attributes["time"] = publishing.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if publishing.CorrelationId != "" {
+		attributes[correlationIdExtension] = publishing.CorrelationId
+	}
+
+	switch config.Mode {
+	case cloudEventsMode.Structured:
+		//The data is embedded as JSON if it is JSON, and base64 encoded otherwise, as the specification requires.
+		attributes["datacontenttype"] = publishing.ContentType
+		if isJSONContentType(publishing.ContentType) {
+			attributes["data"] = json.RawMessage(publishing.Body)
+		} else {
+			attributes["data_base64"] = base64.StdEncoding.EncodeToString(publishing.Body)
+		}
+		body, err := json.Marshal(attributes)
+		if err != nil {
+			return err
+		}
+		publishing.ContentType = cloudEventsContentType
+		publishing.Body = body
+	default:
+		//The AMQP content type already carries the datacontenttype attribute.
+		if publishing.Headers == nil {
+			publishing.Headers = make(amqp.Table, len(attributes))
+		}
+		for name, value := range attributes {
+			publishing.Headers[cloudEventsHeaderPrefix+name] = value
+		}
+	}
+	return nil
+}
+
+//parseCloudEvent returns the CloudEvent a message holds, in either content mode. It returns nil if the message is not a CloudEvent.
+func parseCloudEvent(contentType string, headers amqp.Table, body []byte) (*cloudEvent, error) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == cloudEventsContentType {
+		return parseStructuredCloudEvent(body)
+	}
+
+	attributes := make(map[string]interface{})
+	for name, value := range headers {
+		switch {
+		case strings.HasPrefix(name, cloudEventsHeaderPrefix):
+			attributes[strings.TrimPrefix(name, cloudEventsHeaderPrefix)] = value
+		case strings.HasPrefix(name, legacyCloudEventsHeaderPrefix):
+			attributes[strings.TrimPrefix(name, legacyCloudEventsHeaderPrefix)] = value
+		}
+	}
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	attributes["datacontenttype"] = contentType
+	return newCloudEvent(attributes, body)
+}
+
+//parseStructuredCloudEvent parses the JSON envelope of an event published in structured mode.
+func parseStructuredCloudEvent(body []byte) (*cloudEvent, error) {
+	var envelope map[string]json.RawMessage
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("structured CloudEvent is not a JSON object: %w", err)
+	}
+
+	attributes := make(map[string]interface{}, len(envelope))
+	for name, value := range envelope {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+		var attribute interface{}
+		err = json.Unmarshal(value, &attribute)
+		if err != nil {
+			return nil, fmt.Errorf("structured CloudEvent attribute %s is not valid JSON: %w", name, err)
+		}
+		attributes[name] = attribute
+	}
+	if _, ok := attributes["datacontenttype"]; !ok {
+		attributes["datacontenttype"] = "application/json"
+	}
+
+	var data []byte
+	if encoded, ok := envelope["data_base64"]; ok {
+		var base64Data string
+		err = json.Unmarshal(encoded, &base64Data)
+		if err == nil {
+			data, err = base64.StdEncoding.DecodeString(base64Data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("structured CloudEvent data_base64 is not valid base64: %w", err)
+		}
+	} else if raw, ok := envelope["data"]; ok {
+		data = raw
+		//Data that is not JSON, e.g. plain text, is embedded as a JSON string.
+		var text string
+		if !isJSONContentType(fmt.Sprint(attributes["datacontenttype"])) && json.Unmarshal(raw, &text) == nil {
+			data = []byte(text)
+		}
+	}
+	return newCloudEvent(attributes, data)
+}
+
+//newCloudEvent maps the attributes of an event onto the message model.
+func newCloudEvent(attributes map[string]interface{}, data []byte) (*cloudEvent, error) {
+	event := cloudEvent{data: data}
+	for name, value := range attributes {
+		switch name {
+		case "specversion":
+			event.attributes.SpecVersion = fmt.Sprint(value)
+		case "id":
+			event.id = fmt.Sprint(value)
+		case "source":
+			event.attributes.Source = fmt.Sprint(value)
+		case "type":
+			event.attributes.Type = fmt.Sprint(value)
+		case "subject":
+			event.attributes.Subject = fmt.Sprint(value)
+		case "dataschema":
+			event.attributes.DataSchema = fmt.Sprint(value)
+		case "datacontenttype":
+			event.attributes.DataContentType = fmt.Sprint(value)
+		case "time":
+			eventTime, err := parseCloudEventTime(value)
+			if err != nil {
+				return nil, err
+			}
+			event.time = eventTime
+		case correlationIdExtension:
+			event.correlationId = fmt.Sprint(value)
+		default:
+			if event.attributes.Extensions == nil {
+				event.attributes.Extensions = make(map[string]interface{})
+			}
+			event.attributes.Extensions[name] = value
+		}
+	}
+	if event.attributes.SpecVersion == "" {
+		return nil, errors.New("CloudEvent has no specversion attribute")
+	}
+	return &event, nil
+}
+
+//applyTo maps the id, time and correlationid attributes of the event onto the message, and exposes the other attributes as its CloudEvent.
+func (event *cloudEvent) applyTo(distributedMessage *models.DistributedMessage) {
+	distributedMessage.MessageId = event.id
+	if event.correlationId != "" {
+		distributedMessage.CorrelationId = event.correlationId
+	}
+	if !event.time.IsZero() {
+		distributedMessage.Timestamp = event.time
+	}
+	attributes := event.attributes
+	distributedMessage.CloudEvent = &attributes
+}
+
+//parseCloudEventTime parses the time attribute, which is an RFC 3339 string, or an AMQP timestamp in binary mode.
+func parseCloudEventTime(value interface{}) (time.Time, error) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, nil
+	case string:
+		eventTime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("CloudEvent time attribute is not an RFC 3339 timestamp: %w", err)
+		}
+		return eventTime, nil
+	default:
+		return time.Time{}, fmt.Errorf("CloudEvent time attribute is a %T, not a timestamp", value)
+	}
+}
+
+//isJSONContentType returns true for JSON content types, including "text/json" and types with a "+json" suffix.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
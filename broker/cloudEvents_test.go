@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/cloudEventsMode"
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//publishAndConsume publishes the message as a CloudEvent and decodes it as the subscriber would.
+func publishAndConsume(t *testing.T, config models.PublisherConfig, message models.DistributedMessage, options ...PublishOption) (amqp.Publishing, models.DistributedMessage) {
+	publisher := messagePublisher{config: config, codecs: codec.NewDefaultRegistry(), logger: logs.Logger{}}
+	publishing, err := publisher.newPublishing(message, options)
+	assert.NoError(t, err)
+
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	consumed, err := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{
+		ContentType:   publishing.ContentType,
+		Headers:       publishing.Headers,
+		MessageId:     publishing.MessageId,
+		CorrelationId: publishing.CorrelationId,
+		Body:          publishing.Body,
+	}}, decodeAny)
+	assert.NoError(t, err)
+	return publishing, consumed
+}
+
+func TestCloudEvents_GivenBinaryMode_ShouldCarryAttributesInHeadersAndRoundTrip(t *testing.T) {
+	// Arrange
+	config := models.PublisherConfig{CloudEvents: &models.CloudEventsConfig{Source: "/orders", Type: "order.created"}}
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	message := models.DistributedMessage{Data: map[string]interface{}{"id": "1"}, MessageId: "message", CorrelationId: "correlation", Timestamp: timestamp}
+
+	// Act
+	publishing, consumed := publishAndConsume(t, config, message)
+
+	// Assert
+	assert.Equal(t, "application/json", publishing.ContentType)
+	assert.Equal(t, "1.0", publishing.Headers["cloudEvents_specversion"])
+	assert.Equal(t, "message", publishing.Headers["cloudEvents_id"])
+	assert.Equal(t, "2024-01-02T03:04:05Z", publishing.Headers["cloudEvents_time"])
+	assert.Equal(t, "correlation", publishing.Headers["cloudEvents_correlationid"])
+	assert.Equal(t, map[string]interface{}{"id": "1"}, consumed.Data)
+	assert.Equal(t, "message", consumed.MessageId)
+	assert.Equal(t, "correlation", consumed.CorrelationId)
+	assert.True(t, timestamp.Equal(consumed.Timestamp))
+	assert.Equal(t, &models.CloudEvent{SpecVersion: "1.0", Source: "/orders", Type: "order.created", DataContentType: "application/json"}, consumed.CloudEvent)
+}
+
+func TestCloudEvents_GivenStructuredMode_ShouldWrapPayloadInJSONEnvelopeAndRoundTrip(t *testing.T) {
+	// Arrange
+	config := models.PublisherConfig{CloudEvents: &models.CloudEventsConfig{Mode: cloudEventsMode.Structured, Source: "/orders", Type: "order.created"}}
+	message := models.DistributedMessage{Data: map[string]interface{}{"id": "1"}, MessageId: "message"}
+
+	// Act
+	publishing, consumed := publishAndConsume(t, config, message, WithType("order.shipped"))
+
+	// Assert
+	var envelope map[string]interface{}
+	assert.NoError(t, json.Unmarshal(publishing.Body, &envelope))
+	assert.Equal(t, "application/cloudevents+json", publishing.ContentType)
+	assert.Equal(t, "order.shipped", envelope["type"])
+	assert.Equal(t, map[string]interface{}{"id": "1"}, envelope["data"])
+	assert.Equal(t, map[string]interface{}{"id": "1"}, consumed.Data)
+	assert.Equal(t, "message", consumed.MessageId)
+	assert.Equal(t, "order.shipped", consumed.CloudEvent.Type)
+}
+
+func TestCloudEvents_GivenStructuredModeAndBinaryPayload_ShouldBase64EncodeDataAndRoundTrip(t *testing.T) {
+	// Arrange
+	config := models.PublisherConfig{
+		ContentType: "application/octet-stream",
+		CloudEvents: &models.CloudEventsConfig{Mode: cloudEventsMode.Structured, Source: "/orders", Type: "order.created"},
+	}
+	message := models.DistributedMessage{Data: []byte{1, 2, 3}}
+
+	// Act
+	publishing, consumed := publishAndConsume(t, config, message)
+
+	// Assert
+	var envelope map[string]interface{}
+	assert.NoError(t, json.Unmarshal(publishing.Body, &envelope))
+	assert.Equal(t, "AQID", envelope["data_base64"])
+	assert.NotEmpty(t, consumed.MessageId)
+	assert.Equal(t, []byte{1, 2, 3}, consumed.Data)
+}
+
+func TestParseCloudEvent_GivenStructuredEventWithTextData_ShouldDecodeStringAndExtensions(t *testing.T) {
+	// Arrange
+	body := []byte(`{"specversion":"1.0","id":"1","source":"/s","type":"t","subject":"order-1","datacontenttype":"text/plain","tenant":"acme","data":"hello"}`)
+
+	// Act
+	event, err := parseCloudEvent(cloudEventsContentType, nil, body)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), event.data)
+	assert.Equal(t, "order-1", event.attributes.Subject)
+	assert.Equal(t, map[string]interface{}{"tenant": "acme"}, event.attributes.Extensions)
+}
+
+func TestParseCloudEvent_GivenLegacyBinaryHeaders_ShouldParseEvent(t *testing.T) {
+	// Arrange
+	headers := amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "1", "cloudEvents:time": time.Unix(0, 0)}
+
+	// Act
+	event, err := parseCloudEvent("application/json", headers, []byte(`{}`))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "1", event.id)
+	assert.True(t, time.Unix(0, 0).Equal(event.time))
+}
+
+func TestParseCloudEvent_GivenPlainMessage_ShouldReturnNil(t *testing.T) {
+	// Act
+	event, err := parseCloudEvent("application/json", amqp.Table{"tenant": "acme"}, []byte(`{}`))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestParseCloudEvent_GivenStructuredEventWithoutSpecVersion_ShouldReturnError(t *testing.T) {
+	// Act
+	_, err := parseCloudEvent(cloudEventsContentType, nil, []byte(`{"id":"1","data":{}}`))
+
+	// Assert
+	assert.Error(t, err)
+}
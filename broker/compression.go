@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/compressionAlgorithm"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/streadway/amqp"
+)
+
+//zstdEncoder is safe for concurrent use through EncodeAll, so it is shared by every publisher.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+//decompressor decompresses the bodies of the messages consumed by a subscriber, or returned to a publisher.
+//		The zero value is ready to use. Its zstd decoder is only created once a zstd body is decompressed, and must be released by calling close.
+//		The zstd decoder is bound to the limit of the first zstd body it decompresses, so every call must pass the same limit.
+type decompressor struct {
+	mutex  sync.Mutex
+	zstd   *zstd.Decoder
+	closed bool
+}
+
+//applyCompression compresses the body of the publishing if it reaches the threshold, and labels it with the algorithm as its content encoding.
+//		A publishing whose content encoding was already set by a publish option is left as it is.
+func applyCompression(config models.CompressionConfig, publishing *amqp.Publishing) error {
+	if publishing.ContentEncoding != "" || len(publishing.Body) < config.Threshold() {
+		return nil
+	}
+
+	var compressed []byte
+	switch config.Algorithm {
+	case compressionAlgorithm.Zstd:
+		compressed = zstdEncoder.EncodeAll(publishing.Body, nil)
+	case compressionAlgorithm.Snappy:
+		compressed = snappy.Encode(nil, publishing.Body)
+	default:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		_, err := writer.Write(publishing.Body)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			return err
+		}
+		compressed = buffer.Bytes()
+	}
+
+	publishing.Body = compressed
+	publishing.ContentEncoding = config.Algorithm.String()
+	return nil
+}
+
+//decompress decompresses a body according to its content encoding. A body without a content encoding, or with the "identity" encoding, is returned as it is.
+//		An error wrapping ErrUnknownContentEncoding is returned if the content encoding is not gzip, zstd or snappy.
+//		An error wrapping ErrDecompressedBodyTooLarge is returned if the body decompresses to more than limit bytes. Decompression stops as soon as the limit is passed.
+func (decompressor *decompressor) decompress(contentEncoding string, body []byte, limit int) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return body, nil
+	case compressionAlgorithm.Gzip.String(), "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		decompressed, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > limit {
+			return nil, decompressedBodyTooLarge(contentEncoding, limit)
+		}
+		return decompressed, nil
+	case compressionAlgorithm.Zstd.String():
+		decoder, err := decompressor.zstdDecoder(limit)
+		if err != nil {
+			return nil, err
+		}
+		decompressed, err := decoder.DecodeAll(body, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return nil, decompressedBodyTooLarge(contentEncoding, limit)
+		}
+		return decompressed, err
+	case compressionAlgorithm.Snappy.String():
+		//Snappy records the decompressed length up front, so the body is never decompressed if it is too large.
+		length, err := snappy.DecodedLen(body)
+		if err != nil {
+			return nil, err
+		}
+		if length > limit {
+			return nil, decompressedBodyTooLarge(contentEncoding, limit)
+		}
+		return snappy.Decode(nil, body)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentEncoding, contentEncoding)
+	}
+}
+
+func decompressedBodyTooLarge(contentEncoding string, limit int) error {
+	return fmt.Errorf("%w: %s body decompresses to more than %d bytes", ErrDecompressedBodyTooLarge, contentEncoding, limit)
+}
+
+//zstdDecoder returns the decompressor's zstd decoder, creating it if this is the first zstd body.
+//		The decoder decodes one body at a time and refuses to decode a body to more than limit bytes, which bounds the memory it holds on to.
+func (decompressor *decompressor) zstdDecoder(limit int) (*zstd.Decoder, error) {
+	decompressor.mutex.Lock()
+	defer decompressor.mutex.Unlock()
+
+	if decompressor.closed {
+		return nil, ErrBrokerClosed
+	}
+	if decompressor.zstd == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(limit)))
+		if err != nil {
+			return nil, err
+		}
+		decompressor.zstd = decoder
+	}
+	return decompressor.zstd, nil
+}
+
+//close releases the zstd decoder. Bodies can no longer be decompressed with zstd afterwards.
+func (decompressor *decompressor) close() {
+	decompressor.mutex.Lock()
+	defer decompressor.mutex.Unlock()
+
+	decompressor.closed = true
+	if decompressor.zstd != nil {
+		decompressor.zstd.Close()
+		decompressor.zstd = nil
+	}
+}
@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/compressionAlgorithm"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+func TestCompression_GivenBodyAboveThreshold_ShouldCompressAndRoundTrip(t *testing.T) {
+	for _, algorithm := range []compressionAlgorithm.CompressionAlgorithm{compressionAlgorithm.Gzip, compressionAlgorithm.Zstd, compressionAlgorithm.Snappy} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			// Arrange
+			publisher := messagePublisher{
+				config: models.PublisherConfig{CompressionConfig: &models.CompressionConfig{Algorithm: algorithm}},
+				codecs: codec.NewDefaultRegistry(),
+				logger: logs.Logger{},
+			}
+			report := strings.Repeat("report line ", 1000)
+			subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+
+			// Act
+			publishing, err := publisher.newPublishing(models.DistributedMessage{Data: report}, nil)
+			consumed, decodeErr := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{
+				ContentType:     publishing.ContentType,
+				ContentEncoding: publishing.ContentEncoding,
+				Body:            publishing.Body,
+			}}, decodeAny)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, decodeErr)
+			assert.Equal(t, algorithm.String(), publishing.ContentEncoding)
+			assert.Less(t, len(publishing.Body), len(report))
+			assert.Equal(t, report, consumed.Data)
+		})
+	}
+}
+
+func TestCompression_GivenBodyBelowThreshold_ShouldNotCompress(t *testing.T) {
+	// Arrange
+	publishing := amqp.Publishing{Body: []byte(`"small"`)}
+
+	// Act
+	err := applyCompression(models.CompressionConfig{Algorithm: compressionAlgorithm.Zstd}, &publishing)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, publishing.ContentEncoding)
+	assert.Equal(t, []byte(`"small"`), publishing.Body)
+}
+
+func TestDecode_GivenUnknownContentEncoding_ShouldReturnErrUnknownContentEncoding(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{ContentEncoding: "br", Body: []byte(`{}`)}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrUnknownContentEncoding)
+}
+
+func TestDecode_GivenBodyThatDecompressesPastLimit_ShouldDeadLetterMessage(t *testing.T) {
+	for _, algorithm := range []compressionAlgorithm.CompressionAlgorithm{compressionAlgorithm.Gzip, compressionAlgorithm.Zstd, compressionAlgorithm.Snappy} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			// Arrange
+			publishing := amqp.Publishing{ContentType: "text/plain", Body: []byte(strings.Repeat("a", 64*1024))}
+			applyCompression(models.CompressionConfig{Algorithm: algorithm}, &publishing)
+			acknowledger := &recordingAcknowledger{}
+			subscriber := messageSubscriber{
+				config: models.SubscriberConfig{MaxDecompressedBytes: 1024, DeadLetterConfig: &models.DeadLetterConfig{}},
+				codecs: codec.NewDefaultRegistry(),
+				logger: logs.Logger{},
+			}
+			message := &consumedMessage{delivery: amqp.Delivery{
+				Acknowledger:    acknowledger,
+				ContentType:     publishing.ContentType,
+				ContentEncoding: publishing.ContentEncoding,
+				Body:            publishing.Body,
+			}}
+
+			// Act
+			_, err := subscriber.decode(message, decodeAny)
+			subscriber.handle(message, nil, decodeAny)
+
+			// Assert
+			assert.ErrorIs(t, err, ErrDecompressedBodyTooLarge)
+			assert.True(t, acknowledger.rejected)
+			assert.False(t, acknowledger.requeued)
+		})
+	}
+}
+
+func TestDecode_GivenBodyThatDecompressesToLimit_ShouldDecode(t *testing.T) {
+	for _, algorithm := range []compressionAlgorithm.CompressionAlgorithm{compressionAlgorithm.Gzip, compressionAlgorithm.Zstd, compressionAlgorithm.Snappy} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			// Arrange
+			body := strings.Repeat("a", 2048)
+			publishing := amqp.Publishing{ContentType: "text/plain", Body: []byte(body)}
+			applyCompression(models.CompressionConfig{Algorithm: algorithm}, &publishing)
+			subscriber := messageSubscriber{config: models.SubscriberConfig{MaxDecompressedBytes: len(body)}, codecs: codec.NewDefaultRegistry()}
+
+			// Act
+			consumed, err := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{
+				ContentType:     publishing.ContentType,
+				ContentEncoding: publishing.ContentEncoding,
+				Body:            publishing.Body,
+			}}, decodeAny)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, body, consumed.Data)
+		})
+	}
+}
+
+func TestDecompressor_GivenClosed_ShouldReleaseZstdDecoderAndRefuseZstdBodies(t *testing.T) {
+	// Arrange
+	publishing := amqp.Publishing{Body: []byte(strings.Repeat("a", 2048))}
+	applyCompression(models.CompressionConfig{Algorithm: compressionAlgorithm.Zstd}, &publishing)
+	decompressor := decompressor{}
+	_, err := decompressor.decompress(publishing.ContentEncoding, publishing.Body, 4096)
+
+	// Act
+	decompressor.close()
+	_, closedErr := decompressor.decompress(publishing.ContentEncoding, publishing.Body, 4096)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Nil(t, decompressor.zstd)
+	assert.Equal(t, ErrBrokerClosed, closedErr)
+}
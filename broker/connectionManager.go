@@ -0,0 +1,419 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//clientProduct is the product the broker advertises to RabbitMQ in its client properties.
+const clientProduct = "GoRabbitMqBroker"
+
+//topologyDeclaration declares the exchanges, queues and bindings a publisher or subscriber depends on.
+//Declarations are replayed against every new channel after a reconnect.
+type topologyDeclaration func(channel *amqp.Channel) error
+
+//session is a connection to RabbitMQ together with the channel the connectionManager opened on it.
+//		connectionClosed and channelClosed are notified when the connection or the channel is closed, and shutdown closes them both.
+type session struct {
+	connection       *amqp.Connection
+	channel          *amqp.Channel
+	connectionClosed <-chan *amqp.Error
+	channelClosed    <-chan *amqp.Error
+	shutdown         func()
+}
+
+//connectionManager owns the connection and channel to RabbitMQ.
+//It watches both for closure and transparently reconnects with exponential backoff unless the broker was closed by the user.
+//A broker that publishes and subscribes has a connectionManager for each, so that flow control or a channel exception on one does not affect the other.
+type connectionManager struct {
+	config     models.Config
+	purpose    string
+	tlsConfig  *tls.Config
+	hosts      *hostSelector
+	logger     logs.ILogger
+	mutex      sync.Mutex
+	ready      *sync.Cond
+	done       chan struct{}
+	open       func() (*session, error)
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	shutdown   func()
+	topology   []topologyDeclaration
+	closed     bool
+	err        error
+	blocked    bool
+	unblocked  chan struct{}
+}
+
+//newConnectionManager connects to RabbitMQ. purpose describes what the connection is used for (e.g. "publishing") in logs and in the RabbitMQ management portal.
+func newConnectionManager(config models.Config, purpose string, logger logs.ILogger) (*connectionManager, error) {
+	manager := connectionManager{
+		config:  config,
+		purpose: purpose,
+		hosts:   newHostSelector(config.ConnectionURIs(), config.HostSelection),
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+	manager.ready = sync.NewCond(&manager.mutex)
+	manager.open = manager.openSession
+
+	if config.TLSConfig != nil {
+		tlsConfig, err := config.TLSConfig.BuildTLSConfig()
+		if err != nil {
+			return nil, &ValidationError{Err: err}
+		}
+		manager.tlsConfig = tlsConfig
+	}
+
+	err := manager.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &manager, nil
+}
+
+//addTopology declares the given topology on the current channel and remembers it so that it is declared again after every reconnect.
+func (manager *connectionManager) addTopology(declare topologyDeclaration) error {
+	manager.mutex.Lock()
+	manager.topology = append(manager.topology, declare)
+	channel := manager.channel
+	manager.mutex.Unlock()
+
+	if channel == nil {
+		return ErrNotConnected
+	}
+	return declare(channel)
+}
+
+//currentChannel returns the channel that is open right now.
+//It fails fast, rather than waiting, if the connection is being recovered.
+func (manager *connectionManager) currentChannel() (*amqp.Channel, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if manager.closed {
+		return nil, ErrBrokerClosed
+	}
+	if manager.err != nil {
+		return nil, manager.err
+	}
+	if manager.channel == nil {
+		return nil, ErrNotConnected
+	}
+	return manager.channel, nil
+}
+
+//currentConnection returns the connection that is open right now.
+//It fails fast, rather than waiting, if the connection is being recovered.
+func (manager *connectionManager) currentConnection() (*amqp.Connection, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if manager.closed {
+		return nil, ErrBrokerClosed
+	}
+	if manager.err != nil {
+		return nil, manager.err
+	}
+	if manager.channel == nil {
+		return nil, ErrNotConnected
+	}
+	return manager.connection, nil
+}
+
+//awaitChannel blocks until a channel other than previous is open.
+//It returns an error once the broker has been closed, recovery has given up or the context is done.
+//		Callers waiting on a context must arrange for wake to be called when the context is done.
+func (manager *connectionManager) awaitChannel(ctx context.Context, previous *amqp.Channel) (*amqp.Channel, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	for !manager.closed && manager.err == nil && ctx.Err() == nil && (manager.channel == nil || manager.channel == previous) {
+		manager.ready.Wait()
+	}
+	if manager.closed {
+		return nil, ErrBrokerClosed
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if manager.err != nil {
+		return nil, manager.err
+	}
+	return manager.channel, nil
+}
+
+//awaitUnblocked returns straight away if RabbitMQ has not blocked the connection.
+//Otherwise it blocks until the connection is unblocked or lost, the broker is closed or the context is done.
+func (manager *connectionManager) awaitUnblocked(ctx context.Context) error {
+	manager.mutex.Lock()
+	blocked := manager.blocked
+	unblocked := manager.unblocked
+	manager.mutex.Unlock()
+
+	if !blocked {
+		return nil
+	}
+	select {
+	case <-unblocked:
+		return nil
+	case <-manager.done:
+		return ErrBrokerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//isBlocked returns whether RabbitMQ has blocked the connection because of a memory or disk alarm.
+func (manager *connectionManager) isBlocked() bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.blocked
+}
+
+//watchBlocked tracks whether RabbitMQ has blocked the connection until the connection closes.
+//		RabbitMQ blocks connections that publish while a memory or disk alarm is in effect, and stops reading from them until the alarm clears.
+func (manager *connectionManager) watchBlocked(blockings <-chan amqp.Blocking) {
+	for blocking := range blockings {
+		manager.mutex.Lock()
+		if blocking.Active && !manager.blocked {
+			manager.blocked = true
+			manager.unblocked = make(chan struct{})
+			manager.mutex.Unlock()
+			manager.logger.LogWarning(fmt.Sprintf("RabbitMQ blocked the connection for %s: %s", manager.purpose, blocking.Reason))
+			continue
+		}
+		if !blocking.Active && manager.blocked {
+			manager.setUnblocked()
+			manager.mutex.Unlock()
+			manager.logger.LogInformation(fmt.Sprintf("RabbitMQ unblocked the connection for %s", manager.purpose))
+			continue
+		}
+		manager.mutex.Unlock()
+	}
+}
+
+//setUnblocked records that the connection is no longer blocked and releases anyone waiting for it to be unblocked. The mutex must be held.
+func (manager *connectionManager) setUnblocked() {
+	if manager.blocked {
+		manager.blocked = false
+		close(manager.unblocked)
+	}
+}
+
+//wake wakes up anyone waiting for a channel so that they can check whether their context is done.
+func (manager *connectionManager) wake() {
+	manager.mutex.Lock()
+	manager.ready.Broadcast()
+	manager.mutex.Unlock()
+}
+
+//close stops any recovery in progress and closes the channel and the connection.
+func (manager *connectionManager) close() {
+	manager.mutex.Lock()
+	if manager.closed {
+		manager.mutex.Unlock()
+		return
+	}
+	manager.closed = true
+	close(manager.done)
+	shutdown := manager.shutdown
+	manager.ready.Broadcast()
+	manager.mutex.Unlock()
+
+	if shutdown != nil {
+		shutdown()
+	}
+}
+
+//connect opens a new session, declares the topology on its channel and makes it the current session.
+func (manager *connectionManager) connect() error {
+	opened, err := manager.open()
+	if err != nil {
+		return err
+	}
+
+	manager.mutex.Lock()
+	topology := append([]topologyDeclaration(nil), manager.topology...)
+	manager.mutex.Unlock()
+
+	for _, declare := range topology {
+		err = declare(opened.channel)
+		if err != nil {
+			opened.shutdown()
+			return err
+		}
+	}
+
+	manager.mutex.Lock()
+	if manager.closed {
+		manager.mutex.Unlock()
+		opened.shutdown()
+		return ErrBrokerClosed
+	}
+	manager.connection = opened.connection
+	manager.channel = opened.channel
+	manager.shutdown = opened.shutdown
+	manager.ready.Broadcast()
+	manager.mutex.Unlock()
+
+	go manager.watch(opened)
+	return nil
+}
+
+//openSession connects to the cluster and opens a channel on the connection.
+func (manager *connectionManager) openSession() (*session, error) {
+	connection, err := manager.dialCluster()
+	if err != nil {
+		return nil, &ConnectionError{Err: err}
+	}
+	go manager.watchBlocked(connection.NotifyBlocked(make(chan amqp.Blocking, 1)))
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return nil, &ConnectionError{Err: err}
+	}
+
+	return &session{
+		connection:       connection,
+		channel:          channel,
+		connectionClosed: connection.NotifyClose(make(chan *amqp.Error, 1)),
+		channelClosed:    channel.NotifyClose(make(chan *amqp.Error, 1)),
+		shutdown: func() {
+			channel.Close()
+			connection.Close()
+		},
+	}, nil
+}
+
+//dialCluster tries every host in the order chosen by the host selection strategy and returns the first connection that is opened.
+//		If no host can be connected to, the error from the last host is returned.
+func (manager *connectionManager) dialCluster() (*amqp.Connection, error) {
+	var err error
+	for _, index := range manager.hosts.order() {
+		uri := manager.hosts.uris[index]
+
+		var connection *amqp.Connection
+		connection, err = manager.dial(uri)
+		if err == nil {
+			manager.hosts.connectedTo(index)
+			manager.logger.LogInformation(fmt.Sprintf("Connected to RabbitMQ node %s for %s", nodeName(uri), manager.purpose))
+			return connection, nil
+		}
+		manager.logger.LogInformation(fmt.Sprintf("Failed to connect to RabbitMQ node %s for %s: %s", nodeName(uri), manager.purpose, err))
+	}
+	return nil, err
+}
+
+//dial opens a connection to RabbitMQ, over TLS if it is configured.
+func (manager *connectionManager) dial(uri string) (*amqp.Connection, error) {
+	return amqp.DialConfig(uri, manager.dialConfig())
+}
+
+//dialConfig returns the AMQP configuration a connection is opened with.
+//		The AMQP library fills in the server name of the TLS configuration and adds to the client properties it is given, so every dial is given its own copy.
+func (manager *connectionManager) dialConfig() amqp.Config {
+	connectionConfig := models.ConnectionConfig{}
+	if manager.config.ConnectionConfig != nil {
+		connectionConfig = *manager.config.ConnectionConfig
+	}
+
+	properties := amqp.Table{
+		"product": clientProduct,
+	}
+	for key, value := range connectionConfig.ClientProperties {
+		properties[key] = value
+	}
+	if connectionConfig.ConnectionName != "" {
+		properties["connection_name"] = fmt.Sprintf("%s (%s)", connectionConfig.ConnectionName, manager.purpose)
+	}
+
+	config := amqp.Config{
+		Heartbeat:  connectionConfig.Heartbeat(),
+		ChannelMax: connectionConfig.ChannelMax,
+		FrameSize:  connectionConfig.FrameSize,
+		Locale:     connectionConfig.ClientLocale(),
+		Properties: properties,
+		Dial:       amqp.DefaultDial(connectionConfig.DialTimeout()),
+	}
+	if manager.tlsConfig != nil {
+		config.TLSClientConfig = manager.tlsConfig.Clone()
+	}
+	return config
+}
+
+//watch waits for either the connection or the channel of the session to close and, unless the broker was closed by the user, starts recovery.
+func (manager *connectionManager) watch(current *session) {
+	var reason *amqp.Error
+	select {
+	case reason = <-current.connectionClosed:
+	case reason = <-current.channelClosed:
+	}
+
+	manager.mutex.Lock()
+	if manager.closed {
+		manager.mutex.Unlock()
+		return
+	}
+	manager.channel = nil
+	manager.setUnblocked()
+	manager.mutex.Unlock()
+
+	//A channel exception leaves the connection open, so tear it down before dialing again.
+	current.shutdown()
+	manager.logger.LogInformation(fmt.Sprintf("Connection to RabbitMQ for %s was lost: %v", manager.purpose, reason))
+	manager.reconnect()
+}
+
+func (manager *connectionManager) reconnect() {
+	reconnectConfig := models.ReconnectConfig{}
+	if manager.config.ReconnectConfig != nil {
+		reconnectConfig = *manager.config.ReconnectConfig
+	}
+	if reconnectConfig.Disabled {
+		manager.fail(ErrConnectionLost)
+		return
+	}
+
+	for attempt := 1; reconnectConfig.MaxAttempts == 0 || attempt <= reconnectConfig.MaxAttempts; attempt++ {
+		delay := reconnectConfig.Backoff(attempt)
+		manager.logger.LogInformation(fmt.Sprintf("Reconnecting to RabbitMQ for %s in %s (attempt %d)", manager.purpose, delay, attempt))
+
+		select {
+		case <-manager.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := manager.connect()
+		if err == nil {
+			manager.logger.LogInformation(fmt.Sprintf("Reconnected to RabbitMQ for %s", manager.purpose))
+			return
+		}
+		if err == ErrBrokerClosed {
+			return
+		}
+		manager.logger.LogInformation(fmt.Sprintf("Reconnection attempt %d to RabbitMQ for %s failed: %s", attempt, manager.purpose, err))
+	}
+
+	manager.fail(&ConnectionError{Err: fmt.Errorf("gave up reconnecting after %d attempts", reconnectConfig.MaxAttempts)})
+}
+
+//fail records that the connection cannot be recovered and wakes up anyone waiting for a channel.
+func (manager *connectionManager) fail(err error) {
+	manager.mutex.Lock()
+	manager.err = err
+	manager.ready.Broadcast()
+	manager.mutex.Unlock()
+
+	manager.logger.LogWarning(err.Error())
+}
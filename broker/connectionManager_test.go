@@ -0,0 +1,330 @@
+package broker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//amqpProtocolHeader is the first thing an AMQP 0-9-1 client sends once the connection is open.
+const amqpProtocolHeader = "AMQP\x00\x00\x09\x01"
+
+func TestConnectionURI_GivenCredentialsAndVirtualHostNeedingEscaping_ShouldRoundTripThroughParseURI(t *testing.T) {
+	// Arrange
+	config := models.Config{
+		Username:     "svc@orders",
+		Password:     "p@ss/w%rd:1",
+		RabbitMqHost: "rabbit.example.com",
+		Port:         5673,
+		VirtualHost:  "orders/eu west",
+	}
+
+	// Act
+	uri, err := amqp.ParseURI(config.ConnectionURI())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "amqp", uri.Scheme)
+	assert.Equal(t, "svc@orders", uri.Username)
+	assert.Equal(t, "p@ss/w%rd:1", uri.Password)
+	assert.Equal(t, "rabbit.example.com", uri.Host)
+	assert.Equal(t, 5673, uri.Port)
+	assert.Equal(t, "orders/eu west", uri.Vhost)
+}
+
+func TestDial_GivenTLSConfigWithClientCertificate_ShouldPresentCertificateAndSpeakAmqp(t *testing.T) {
+	// Arrange
+	directory := t.TempDir()
+	caCertificate, caKey := newTestCertificate(t, directory, "ca", nil, nil)
+	serverCertificate, serverKey := newTestCertificate(t, directory, "server", caCertificate, caKey)
+	newTestCertificate(t, directory, "client", caCertificate, caKey)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCertificate)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCertificate.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caPool,
+	})
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	type handshake struct {
+		clientName string
+		header     string
+	}
+	handshakes := make(chan handshake, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		connection.SetDeadline(time.Now().Add(5 * time.Second))
+
+		header := make([]byte, len(amqpProtocolHeader))
+		_, err = io.ReadFull(connection, header)
+		if err != nil {
+			return
+		}
+		state := connection.(*tls.Conn).ConnectionState()
+		handshakes <- handshake{clientName: state.PeerCertificates[0].Subject.CommonName, header: string(header)}
+	}()
+
+	tlsConfig, err := models.TLSConfig{
+		CACertFile: filepath.Join(directory, "ca.pem"),
+		CertFile:   filepath.Join(directory, "client.pem"),
+		KeyFile:    filepath.Join(directory, "client-key.pem"),
+		ServerName: "localhost",
+	}.BuildTLSConfig()
+	assert.NoError(t, err)
+	manager := connectionManager{tlsConfig: tlsConfig}
+
+	// Act
+	_, err = manager.dial("amqps://guest:guest@" + listener.Addr().String() + "/")
+
+	// Assert
+	assert.Error(t, err) //The stand-in listener hangs up after reading the protocol header.
+	select {
+	case result := <-handshakes:
+		assert.Equal(t, "client", result.clientName)
+		assert.Equal(t, amqpProtocolHeader, result.header)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stand-in listener never completed a TLS handshake")
+	}
+}
+
+//newTestCertificate creates a certificate for localhost, signed by the given parent or self-signed if there is no parent, and writes it and its key to <name>.pem and <name>-key.pem.
+func newTestCertificate(t *testing.T, directory string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(directory, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(directory, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	assert.NoError(t, err)
+
+	return certificate, key
+}
+
+func TestDialConfig_GivenConnectionConfig_ShouldApplySettingsAndClientProperties(t *testing.T) {
+	// Arrange
+	manager := connectionManager{purpose: "publishing", config: models.Config{
+		ConnectionConfig: &models.ConnectionConfig{
+			HeartbeatSeconds: 5,
+			ChannelMax:       16,
+			FrameSize:        8192,
+			ConnectionName:   "orders-service",
+			ClientProperties: map[string]interface{}{"team": "payments"},
+		},
+	}}
+	expectedProperties := amqp.Table{
+		"product":         clientProduct,
+		"connection_name": "orders-service (publishing)",
+		"team":            "payments",
+	}
+
+	// Act
+	config := manager.dialConfig()
+
+	// Assert
+	assert.Equal(t, 5*time.Second, config.Heartbeat)
+	assert.Equal(t, 16, config.ChannelMax)
+	assert.Equal(t, 8192, config.FrameSize)
+	assert.Equal(t, "en_US", config.Locale)
+	assert.Equal(t, expectedProperties, config.Properties)
+	assert.NotNil(t, config.Dial)
+	assert.Nil(t, config.TLSClientConfig)
+}
+
+//fakeSessionOpener opens sessions that are never connected to RabbitMQ, so that a test can lose them on demand.
+//		Once failures is set, that many attempts to open a session fail before the next one succeeds.
+type fakeSessionOpener struct {
+	mutex    sync.Mutex
+	failures int
+	attempts []time.Time
+	lost     []chan *amqp.Error
+}
+
+func (opener *fakeSessionOpener) open() (*session, error) {
+	opener.mutex.Lock()
+	defer opener.mutex.Unlock()
+
+	opener.attempts = append(opener.attempts, time.Now())
+	if opener.failures > 0 {
+		opener.failures--
+		return nil, &ConnectionError{Err: errors.New("connection refused")}
+	}
+
+	connectionClosed := make(chan *amqp.Error, 1)
+	opener.lost = append(opener.lost, connectionClosed)
+	return &session{
+		connection:       &amqp.Connection{},
+		channel:          &amqp.Channel{},
+		connectionClosed: connectionClosed,
+		channelClosed:    make(chan *amqp.Error),
+		shutdown:         func() {},
+	}, nil
+}
+
+//loseConnection closes the connection of the session that was opened last, as RabbitMQ does when it is restarted.
+func (opener *fakeSessionOpener) loseConnection(failures int) time.Time {
+	opener.mutex.Lock()
+	defer opener.mutex.Unlock()
+
+	opener.failures = failures
+	opener.lost[len(opener.lost)-1] <- &amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED"}
+	return time.Now()
+}
+
+func (opener *fakeSessionOpener) attemptTimes() []time.Time {
+	opener.mutex.Lock()
+	defer opener.mutex.Unlock()
+	return append([]time.Time(nil), opener.attempts...)
+}
+
+//newTestConnectionManager returns a connection manager that is connected through the fake opener.
+func newTestConnectionManager(t *testing.T, reconnectConfig models.ReconnectConfig, opener *fakeSessionOpener) *connectionManager {
+	manager := &connectionManager{
+		config:  models.Config{ReconnectConfig: &reconnectConfig},
+		purpose: "consuming",
+		logger:  logs.Logger{},
+		done:    make(chan struct{}),
+		open:    opener.open,
+	}
+	manager.ready = sync.NewCond(&manager.mutex)
+	assert.NoError(t, manager.connect())
+	t.Cleanup(manager.close)
+	return manager
+}
+
+//awaitNextChannel waits for a channel other than previous, failing the test if recovery never finishes.
+func awaitNextChannel(t *testing.T, manager *connectionManager, previous *amqp.Channel) (*amqp.Channel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopWaking := context.AfterFunc(ctx, manager.wake)
+	defer stopWaking()
+	return manager.awaitChannel(ctx, previous)
+}
+
+func TestReconnect_GivenConnectionLost_ShouldReplayTopologyOnNewChannelAndWakeWaiters(t *testing.T) {
+	// Arrange
+	opener := &fakeSessionOpener{}
+	manager := newTestConnectionManager(t, models.ReconnectConfig{InitialIntervalMilliseconds: 1}, opener)
+	var declaredMutex sync.Mutex
+	var declared []*amqp.Channel
+	manager.addTopology(func(channel *amqp.Channel) error {
+		declaredMutex.Lock()
+		declared = append(declared, channel)
+		declaredMutex.Unlock()
+		return nil
+	})
+	previous, _ := manager.currentChannel()
+
+	// Act
+	opener.loseConnection(0)
+	recovered, err := awaitNextChannel(t, manager, previous)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotSame(t, previous, recovered)
+	declaredMutex.Lock()
+	defer declaredMutex.Unlock()
+	assert.Equal(t, []*amqp.Channel{previous, recovered}, declared)
+}
+
+func TestReconnect_GivenFailedAttempts_ShouldBackOffBetweenAttempts(t *testing.T) {
+	// Arrange
+	opener := &fakeSessionOpener{}
+	manager := newTestConnectionManager(t, models.ReconnectConfig{InitialIntervalMilliseconds: 20, Multiplier: 2}, opener)
+	previous, _ := manager.currentChannel()
+
+	// Act
+	lostAt := opener.loseConnection(2)
+	_, err := awaitNextChannel(t, manager, previous)
+
+	// Assert
+	assert.NoError(t, err)
+	attempts := opener.attemptTimes()
+	assert.Len(t, attempts, 4)
+	assert.GreaterOrEqual(t, attempts[1].Sub(lostAt), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, attempts[2].Sub(attempts[1]), 40*time.Millisecond)
+	assert.GreaterOrEqual(t, attempts[3].Sub(attempts[2]), 80*time.Millisecond)
+}
+
+func TestReconnect_GivenReconnectDisabled_ShouldFailCallersForGood(t *testing.T) {
+	// Arrange
+	opener := &fakeSessionOpener{}
+	manager := newTestConnectionManager(t, models.ReconnectConfig{Disabled: true}, opener)
+	previous, _ := manager.currentChannel()
+
+	// Act
+	opener.loseConnection(0)
+	_, awaitErr := awaitNextChannel(t, manager, previous)
+	_, currentErr := manager.currentChannel()
+
+	// Assert
+	assert.Equal(t, ErrConnectionLost, awaitErr)
+	assert.Equal(t, ErrConnectionLost, currentErr)
+	assert.Len(t, opener.attemptTimes(), 1)
+}
+
+func TestReconnect_GivenMaxAttemptsExhausted_ShouldFailCallersWithConnectionError(t *testing.T) {
+	// Arrange
+	opener := &fakeSessionOpener{}
+	manager := newTestConnectionManager(t, models.ReconnectConfig{InitialIntervalMilliseconds: 1, MaxAttempts: 2}, opener)
+	previous, _ := manager.currentChannel()
+
+	// Act
+	opener.loseConnection(100)
+	_, err := awaitNextChannel(t, manager, previous)
+
+	// Assert
+	var connectionErr *ConnectionError
+	assert.ErrorAs(t, err, &connectionErr)
+	assert.Len(t, opener.attemptTimes(), 3)
+}
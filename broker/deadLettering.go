@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//deathHeader is the header RabbitMQ records the dead-lettering history of a message in.
+const deathHeader = "x-death"
+
+//deadLetterArguments returns the arguments the subscriber's queue is declared with so that RabbitMQ dead-letters its messages. It is nil if dead-lettering is not configured.
+func (subscriber *messageSubscriber) deadLetterArguments() amqp.Table {
+	if subscriber.config.DeadLetterConfig == nil {
+		return nil
+	}
+
+	deadLetterConfig := *subscriber.config.DeadLetterConfig
+	arguments := amqp.Table{
+		"x-dead-letter-exchange": deadLetterConfig.DeadLetterExchange(subscriber.config.QueueName),
+	}
+	if deadLetterConfig.RoutingKey != "" {
+		arguments["x-dead-letter-routing-key"] = deadLetterConfig.RoutingKey
+	}
+	return arguments
+}
+
+//declareDeadLetterTopology declares the dead-letter exchange and queue, and binds the queue to the exchange.
+//		The dead-letter queue is bound with the dead-letter routing key if one is configured, otherwise with the subscriber's routing key, as that is the routing key dead-lettered messages keep.
+func (subscriber *messageSubscriber) declareDeadLetterTopology(channel *amqp.Channel, deadLetterConfig models.DeadLetterConfig) error {
+	exchangeName := deadLetterConfig.DeadLetterExchange(subscriber.config.QueueName)
+	err := channel.ExchangeDeclare(
+		exchangeName,
+		deadLetterConfig.BindingType.String(),
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return &TopologyError{Entity: "dead-letter exchange", Name: exchangeName, Err: err}
+	}
+
+	queueName := deadLetterConfig.DeadLetterQueue(subscriber.config.QueueName)
+	_, err = channel.QueueDeclare(
+		queueName,
+		subscriber.config.Durable,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return &TopologyError{Entity: "dead-letter queue", Name: queueName, Err: err}
+	}
+
+	routingKey := deadLetterConfig.RoutingKey
+	if routingKey == "" {
+		routingKey = subscriber.config.RoutingKey
+	}
+	err = channel.QueueBind(
+		queueName,
+		routingKey,
+		exchangeName,
+		false,
+		nil,
+	)
+	if err != nil {
+		return &TopologyError{Entity: "binding to dead-letter exchange", Name: exchangeName, Err: err}
+	}
+
+	return nil
+}
+
+//deathHistory converts the "x-death" header RabbitMQ adds to dead-lettered messages into death records, most recent first.
+//		Entries that are not well-formed are skipped.
+func deathHistory(headers amqp.Table) []models.DeathRecord {
+	entries, ok := headers[deathHeader].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var history []models.DeathRecord
+	for _, entry := range entries {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+
+		record := models.DeathRecord{}
+		record.Queue, _ = table["queue"].(string)
+		record.Reason, _ = table["reason"].(string)
+		record.Exchange, _ = table["exchange"].(string)
+		record.Time, _ = table["time"].(time.Time)
+		switch count := table["count"].(type) {
+		case int64:
+			record.Count = count
+		case int32:
+			record.Count = int64(count)
+		}
+		routingKeys, _ := table["routing-keys"].([]interface{})
+		for _, routingKey := range routingKeys {
+			if key, ok := routingKey.(string); ok {
+				record.RoutingKeys = append(record.RoutingKeys, key)
+			}
+		}
+		history = append(history, record)
+	}
+	return history
+}
@@ -0,0 +1,70 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+func TestDeathHistory_GivenDeathHeader_ShouldReturnDeathRecords(t *testing.T) {
+	// Arrange
+	deadLetteredAt := time.Date(2018, 10, 28, 12, 0, 0, 0, time.UTC)
+	headers := amqp.Table{
+		deathHeader: []interface{}{
+			amqp.Table{
+				"queue":        "orders",
+				"reason":       "rejected",
+				"count":        int64(2),
+				"exchange":     "orders-exchange",
+				"routing-keys": []interface{}{"orders.created"},
+				"time":         deadLetteredAt,
+			},
+		},
+	}
+	expectedHistory := []models.DeathRecord{
+		{
+			Queue:       "orders",
+			Reason:      "rejected",
+			Count:       2,
+			Exchange:    "orders-exchange",
+			RoutingKeys: []string{"orders.created"},
+			Time:        deadLetteredAt,
+		},
+	}
+
+	// Act
+	history := deathHistory(headers)
+
+	// Assert
+	assert.Equal(t, expectedHistory, history)
+}
+
+func TestDeathHistory_GivenNoDeathHeader_ShouldReturnNil(t *testing.T) {
+	// Act
+	history := deathHistory(amqp.Table{})
+
+	// Assert
+	assert.Nil(t, history)
+}
+
+func TestDeadLetterArguments_GivenDeadLetterConfig_ShouldPointQueueAtDeadLetterExchange(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{config: models.SubscriberConfig{
+		QueueName:        "orders",
+		DeadLetterConfig: &models.DeadLetterConfig{RoutingKey: "dead"},
+	}}
+	expectedArguments := amqp.Table{
+		"x-dead-letter-exchange":    "orders.dlx",
+		"x-dead-letter-routing-key": "dead",
+	}
+
+	// Act
+	arguments := subscriber.deadLetterArguments()
+
+	// Assert
+	assert.Equal(t, expectedArguments, arguments)
+}
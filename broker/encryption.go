@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/encryption"
+	"github.com/streadway/amqp"
+)
+
+//applyEncryption encrypts the body of the publishing with the key provider's current key, and records the id of the key in the encryption.KeyIdHeader header.
+func applyEncryption(keyProvider encryption.IKeyProvider, publishing *amqp.Publishing) error {
+	keyId, envelope, err := encryption.Encrypt(keyProvider, publishing.Body)
+	if err != nil {
+		return err
+	}
+
+	publishing.Body = envelope
+	if publishing.Headers == nil {
+		publishing.Headers = make(amqp.Table, 1)
+	}
+	publishing.Headers[encryption.KeyIdHeader] = keyId
+	return nil
+}
+
+//decrypt decrypts a body that was encrypted by applyEncryption. A body without the encryption.KeyIdHeader header is returned as it is.
+//		An error wrapping encryption.ErrDecryptionFailed is returned if the body cannot be decrypted, including when no key provider is set.
+func decrypt(keyProvider encryption.IKeyProvider, headers amqp.Table, body []byte) ([]byte, error) {
+	header, ok := headers[encryption.KeyIdHeader]
+	if !ok {
+		return body, nil
+	}
+	keyId, ok := header.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: the %s header is a %T, not a string", encryption.ErrDecryptionFailed, encryption.KeyIdHeader, header)
+	}
+	if keyProvider == nil {
+		return nil, fmt.Errorf("%w: the message is encrypted with key %s, but no key provider is set", encryption.ErrDecryptionFailed, keyId)
+	}
+	return encryption.Decrypt(keyProvider, keyId, body)
+}
@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/encryption"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
+	"github.com/streadway/amqp"
+)
+
+func newTestKeyRing(t *testing.T, keyId string) *encryption.KeyRing {
+	keyRing, err := encryption.NewKeyRing(keyId, map[string][]byte{keyId: bytes.Repeat([]byte{1}, 32)})
+	assert.NoError(t, err)
+	return keyRing
+}
+
+func TestEncryption_GivenKeyProvider_ShouldEncryptCompressedBodyAndRoundTrip(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t, "2024-01")
+	publisher := messagePublisher{
+		config:         models.PublisherConfig{CompressionConfig: &models.CompressionConfig{}},
+		codecs:         codec.NewDefaultRegistry(),
+		encryptionKeys: keyRing,
+		logger:         logs.Logger{},
+	}
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry(), encryptionKeys: keyRing}
+	report := strings.Repeat("sensitive ", 1000)
+
+	// Act
+	publishing, err := publisher.newPublishing(models.DistributedMessage{Data: report}, nil)
+	consumed, decodeErr := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{
+		ContentType:     publishing.ContentType,
+		ContentEncoding: publishing.ContentEncoding,
+		Headers:         publishing.Headers,
+		Body:            publishing.Body,
+	}}, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, decodeErr)
+	assert.Equal(t, "2024-01", publishing.Headers[encryption.KeyIdHeader])
+	assert.Equal(t, "gzip", publishing.ContentEncoding)
+	assert.NotContains(t, string(publishing.Body), "sensitive")
+	assert.Equal(t, report, consumed.Data)
+}
+
+func TestDecode_GivenMessageEncryptedWithUnknownKey_ShouldReturnErrDecryptionFailed(t *testing.T) {
+	// Arrange
+	_, envelope, _ := encryption.Encrypt(newTestKeyRing(t, "2024-01"), []byte(`"hello"`))
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry(), encryptionKeys: newTestKeyRing(t, "2024-02")}
+	message := &consumedMessage{delivery: amqp.Delivery{Headers: amqp.Table{encryption.KeyIdHeader: "2024-01"}, Body: envelope}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.ErrorIs(t, err, encryption.ErrDecryptionFailed)
+}
+
+func TestDecode_GivenEncryptedMessageAndNoKeyProvider_ShouldReturnErrDecryptionFailed(t *testing.T) {
+	// Arrange
+	_, envelope, _ := encryption.Encrypt(newTestKeyRing(t, "2024-01"), []byte(`"hello"`))
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{Headers: amqp.Table{encryption.KeyIdHeader: "2024-01"}, Body: envelope}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.ErrorIs(t, err, encryption.ErrDecryptionFailed)
+}
+
+func TestSetKeyProvider_GivenSubscriberWithoutDeadLetterConfig_ShouldReturnValidationError(t *testing.T) {
+	// Arrange
+	rmqBroker := &messageBroker{subscriber: &messageSubscriber{config: models.SubscriberConfig{QueueName: "orders"}}}
+
+	// Act
+	err := rmqBroker.SetKeyProvider(newTestKeyRing(t, "2024-01"))
+
+	// Assert
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Nil(t, rmqBroker.subscriber.encryptionKeys)
+}
+
+func TestSetKeyProvider_GivenPublisherOnlyBroker_ShouldEncryptWithoutDeadLetterConfig(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t, "2024-01")
+	rmqBroker := &messageBroker{publisher: &messagePublisher{}}
+
+	// Act
+	err := rmqBroker.SetKeyProvider(keyRing)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Same(t, keyRing, rmqBroker.publisher.encryptionKeys)
+}
+
+//recordingDispositionHandler acknowledges every message and records that it was called.
+type recordingDispositionHandler struct {
+	handled bool
+}
+
+func (handler *recordingDispositionHandler) HandleDelivery(distributedMessage models.DistributedMessage) processing.Disposition {
+	handler.handled = true
+	return processing.Ack()
+}
+
+func TestHandle_GivenMessageThatCannotBeDecrypted_ShouldRejectItToDeadLetterExchange(t *testing.T) {
+	// Arrange
+	_, envelope, _ := encryption.Encrypt(newTestKeyRing(t, "2024-01"), []byte(`"hello"`))
+	subscriber := &messageSubscriber{
+		config: models.SubscriberConfig{QueueName: "orders", DeadLetterConfig: &models.DeadLetterConfig{}},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	rmqBroker := &messageBroker{subscriber: subscriber}
+	err := rmqBroker.SetKeyProvider(newTestKeyRing(t, "2024-02"))
+	acknowledger := &recordingAcknowledger{}
+	message := &consumedMessage{delivery: amqp.Delivery{
+		Acknowledger: acknowledger,
+		Headers:      amqp.Table{encryption.KeyIdHeader: "2024-01"},
+		Body:         envelope,
+	}}
+	handler := &recordingDispositionHandler{}
+
+	// Act
+	subscriber.handle(message, handler, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, handler.handled)
+	assert.True(t, acknowledger.rejected)
+	assert.False(t, acknowledger.requeued)
+	assert.Equal(t, "orders.dlx", subscriber.deadLetterArguments()["x-dead-letter-exchange"])
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrBrokerClosed is returned when the broker is used after Close has been called.
+	ErrBrokerClosed = errors.New("the RabbitMQ broker has been closed")
+
+	//ErrNotConnected is returned when the connection to RabbitMQ was lost and is still being recovered.
+	ErrNotConnected = errors.New("the connection to RabbitMQ was lost and is being recovered")
+
+	//ErrConnectionLost is returned when the connection to RabbitMQ was lost and reconnection is disabled.
+	ErrConnectionLost = errors.New("the connection to RabbitMQ was lost and reconnection is disabled")
+
+	//ErrNotPublisher is returned when publishing through a broker that was not setup as a publisher.
+	ErrNotPublisher = errors.New("RabbitMQ broker was not setup as a publisher. Cannot publish")
+
+	//ErrNotSubscriber is returned when subscribing through a broker that was not setup as a subscriber.
+	ErrNotSubscriber = errors.New("RabbitMQ broker was not setup as a subscriber. Cannot subscribe")
+
+	//ErrUnsupportedBroker is returned by the generic Subscribe and SubscribeWithDisposition functions when the broker was not created by one of this package's constructors.
+	ErrUnsupportedBroker = errors.New("the generic subscribe functions only support brokers created by this package's constructors")
+
+	//ErrShutdownTimeout is returned by Subscribe when messages were still being handled once the subscriber config's shutdown timeout elapsed.
+	ErrShutdownTimeout = errors.New("timed out waiting for messages being handled to finish")
+
+	//ErrConfirmModeDisabled is returned by PublishAsync when the publisher config does not enable confirm mode.
+	ErrConfirmModeDisabled = errors.New("publisherConfig.confirmMode is false. Cannot publish asynchronously without confirmations")
+
+	//ErrPublishNacked is returned when RabbitMQ refused to accept a message published in confirm mode.
+	ErrPublishNacked = errors.New("RabbitMQ nacked the published message")
+
+	//ErrConfirmTimeout is returned when RabbitMQ did not confirm a message published in confirm mode in time.
+	ErrConfirmTimeout = errors.New("timed out waiting for RabbitMQ to confirm the published message")
+
+	//ErrConnectionBlocked is returned by a publish when RabbitMQ has blocked the connection because of a memory or disk alarm and the publisher config's blocked policy is to fail fast.
+	ErrConnectionBlocked = errors.New("RabbitMQ has blocked the connection because of a resource alarm")
+
+	//ErrConfirmationLost is returned when the channel closed before RabbitMQ confirmed a message published in confirm mode.
+	ErrConfirmationLost = errors.New("the channel was closed before RabbitMQ confirmed the published message")
+
+	//ErrUnknownContentEncoding is the reason a consumed message is dead-lettered when its body is compressed with an algorithm the subscriber cannot decompress.
+	ErrUnknownContentEncoding = errors.New("the content encoding of the message is not supported")
+
+	//ErrPayloadTypeMismatch is the reason a consumed message is dead-lettered by a typed subscription when the message records a payload type other than the one the subscription decodes into.
+	ErrPayloadTypeMismatch = errors.New("the payload type recorded on the message does not match the type the subscription decodes into")
+
+	//ErrDecompressedBodyTooLarge is the reason a consumed message is dead-lettered when its body decompresses to more than the subscriber config's MaxDecompressedBytes.
+	ErrDecompressedBodyTooLarge = errors.New("the decompressed body of the message is larger than the subscriber allows")
+)
+
+//ValidationError is returned by the constructors when the configuration is not well-formed.
+//Err is the reason the configuration was rejected.
+type ValidationError struct {
+	Err error
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("validation of the configuration failed: %s", err.Err)
+}
+
+//Unwrap returns the reason the configuration was rejected.
+func (err *ValidationError) Unwrap() error {
+	return err.Err
+}
+
+//ConnectionError is returned when the broker cannot dial RabbitMQ or open a channel on the connection.
+//Err is the error returned by the AMQP library.
+type ConnectionError struct {
+	Err error
+}
+
+func (err *ConnectionError) Error() string {
+	return fmt.Sprintf("failed to connect to RabbitMQ broker: %s", err.Err)
+}
+
+//Unwrap returns the error returned by the AMQP library.
+func (err *ConnectionError) Unwrap() error {
+	return err.Err
+}
+
+//TopologyError is returned when RabbitMQ refuses to declare an exchange or queue, bind a queue, or configure a channel.
+//Entity describes what was being declared (e.g. "exchange", "queue", "binding").
+//Name is the name of the exchange or queue that was being declared.
+//Err is the error returned by the AMQP library.
+type TopologyError struct {
+	Entity string
+	Name   string
+	Err    error
+}
+
+func (err *TopologyError) Error() string {
+	return fmt.Sprintf("error occurred while declaring %s %q: %s", err.Entity, err.Name, err.Err)
+}
+
+//Unwrap returns the error returned by the AMQP library.
+func (err *TopologyError) Unwrap() error {
+	return err.Err
+}
+
+//UnroutableError is returned by Publish in confirm mode when RabbitMQ returned the message because it could not be routed to any queue.
+//ReplyCode and ReplyText are the reason RabbitMQ gave for returning the message.
+//Exchange and RoutingKey are where the message was published to.
+type UnroutableError struct {
+	ReplyCode  uint16
+	ReplyText  string
+	Exchange   string
+	RoutingKey string
+}
+
+func (err *UnroutableError) Error() string {
+	return fmt.Sprintf("RabbitMQ returned the message published to exchange=%s with routing key=%s as unroutable: %d %s",
+		err.Exchange,
+		err.RoutingKey,
+		err.ReplyCode,
+		err.ReplyText)
+}
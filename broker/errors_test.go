@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/streadway/amqp"
+)
+
+func TestValidationError_GivenReason_ShouldUnwrapToReason(t *testing.T) {
+	// Arrange
+	reason := errors.New("username is empty string")
+	var err error = &ValidationError{Err: reason}
+
+	// Act
+	var validationErr *ValidationError
+	isValidationErr := errors.As(err, &validationErr)
+
+	// Assert
+	assert.True(t, isValidationErr)
+	assert.True(t, errors.Is(err, reason))
+	assert.Same(t, reason, errors.Unwrap(err))
+}
+
+func TestConnectionError_GivenAMQPError_ShouldUnwrapToAMQPError(t *testing.T) {
+	// Arrange
+	var err error = &ConnectionError{Err: amqp.ErrClosed}
+
+	// Act
+	var connectionErr *ConnectionError
+	isConnectionErr := errors.As(err, &connectionErr)
+
+	// Assert
+	assert.True(t, isConnectionErr)
+	assert.True(t, errors.Is(err, amqp.ErrClosed))
+	assert.Contains(t, err.Error(), amqp.ErrClosed.Error())
+}
+
+func TestTopologyError_GivenAMQPError_ShouldUnwrapToAMQPErrorAndNameEntity(t *testing.T) {
+	// Arrange
+	refused := &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - inequivalent arg 'durable'"}
+	var err error = &TopologyError{Entity: "queue", Name: "orders", Err: refused}
+
+	// Act
+	var topologyErr *TopologyError
+	isTopologyErr := errors.As(err, &topologyErr)
+	var amqpErr *amqp.Error
+	isAMQPErr := errors.As(err, &amqpErr)
+
+	// Assert
+	assert.True(t, isTopologyErr)
+	assert.Equal(t, "orders", topologyErr.Name)
+	assert.True(t, isAMQPErr)
+	assert.Equal(t, amqp.PreconditionFailed, amqpErr.Code)
+	assert.Contains(t, err.Error(), `queue "orders"`)
+}
+
+func TestErrors_GivenDifferentKinds_ShouldNotMatchEachOther(t *testing.T) {
+	// Arrange
+	var err error = &ConnectionError{Err: amqp.ErrClosed}
+
+	// Act
+	var validationErr *ValidationError
+	var topologyErr *TopologyError
+
+	// Assert
+	assert.False(t, errors.As(err, &validationErr))
+	assert.False(t, errors.As(err, &topologyErr))
+}
@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/hostSelection"
+)
+
+//hostSelector decides the order in which the hosts of a cluster are tried on every connection attempt.
+//It is only used by connect, which is never called concurrently, so it does not need to be safe for concurrent use.
+type hostSelector struct {
+	uris     []string
+	strategy hostSelection.HostSelection
+	next     int
+	random   *rand.Rand
+}
+
+func newHostSelector(uris []string, strategy hostSelection.HostSelection) *hostSelector {
+	return &hostSelector{
+		uris:     uris,
+		strategy: strategy,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+//order returns the indexes of the URIs in the order they should be tried on this attempt.
+func (selector *hostSelector) order() []int {
+	count := len(selector.uris)
+	start := 0
+	switch selector.strategy {
+	case hostSelection.RoundRobin:
+		start = selector.next
+	case hostSelection.Random:
+		start = selector.random.Intn(count)
+	case hostSelection.Shuffled:
+		return selector.random.Perm(count)
+	}
+
+	order := make([]int, 0, count)
+	for offset := 0; offset < count; offset++ {
+		order = append(order, (start+offset)%count)
+	}
+	return order
+}
+
+//connectedTo records the host that was connected to, so that round-robin selection starts with the host after it next time.
+func (selector *hostSelector) connectedTo(index int) {
+	selector.next = (index + 1) % len(selector.uris)
+}
+
+//nodeName returns the host and port of the URI without the credentials, so that it can be logged.
+func nodeName(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "unknown"
+	}
+	return parsed.Host
+}
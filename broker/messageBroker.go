@@ -8,180 +8,357 @@
 package broker
 
 import (
-	"fmt"
+	"context"
+	"errors"
 
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/encryption"
 	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/metrics"
 	"github.com/KrylixZA/GoRabbitMqBroker/models"
 	"github.com/KrylixZA/GoRabbitMqBroker/processing"
-	"github.com/streadway/amqp"
 )
 
-const rmqConnectionStringTemplate = "amqp://%s:%s@%s:5672/%s"
-
 //IMessageBroker exposes an interface through which users can interact with a RabbitMQ broker.
 //Publish exposes functionality to publish an instance of the IDistributedMessageInterface to the configured exchange with the given routing key.
 //		The routing key can be a direct routing key, or wildcard if the exchange is configured as a Topic based exchange.
 //		The distributed message is an implementation of the IDistributedMessage interface.
-//Subscribe exposes functionality to consume messages from a RabbitMQ queue.
+//		Publish options (e.g. WithHeaders, WithPriority, WithExpiration, WithType, WithReplyTo or Transient) customise the AMQP properties of the message.
+//		When the publisher config enables confirm mode, Publish waits for RabbitMQ to acknowledge the message and returns an error if it is nacked or not confirmed in time.
+//		Publish is safe to call from many goroutines at once. Each publish leases a channel from a pool whose size is the publisher config's ChannelPoolSize.
+//PublishWithContext behaves like Publish, but gives up once the context is cancelled or its deadline passes.
+//		While RabbitMQ has blocked the connection because of a memory or disk alarm, the publisher config's BlockedPolicy decides whether to wait or fail with ErrConnectionBlocked.
+//PublishAsync exposes functionality to publish in confirm mode without waiting for the acknowledgement.
+//		The returned channel receives exactly one Confirmation once RabbitMQ acknowledges or nacks the message, or the channel is lost.
+//		The caller is responsible for deciding how long to wait for the confirmation.
+//SetReturnHandler registers an implementation of the IReturnHandler interface that is told about messages RabbitMQ could not route.
+//		Messages are only returned when the publisher config sets MandatoryQueueBind. In confirm mode Publish also fails with an *UnroutableError.
+//Subscribe exposes functionality to consume messages from a RabbitMQ queue until the context is cancelled.
 //		The handler is a delegate to an implementation of the IMessageHandler interface. This has a HandleMessage function which processes the consumed message.
 //		The distributed message is an implementation of the IDistributedMessage interface.
-//Close provides a simple endpoint to close the channel and the connection from RabbitMQ.
+//		If the connection to RabbitMQ is lost, the broker reconnects in the background and consumption resumes once the connection is recovered.
+//		Once the context is cancelled, no new messages are taken and Subscribe returns after the messages being handled have finished and been acknowledged.
+//		Messages are handled by a fixed pool of workers whose size is the subscriber config's Concurrency.
+//		When ordered processing is enabled, messages with the same key are handled one at a time, in the order they were consumed.
+//		A nil error returned by the handler acknowledges the message. Any other error nacks the message and requeues it if the subscriber config sets RequeueOnNack.
+//		If the subscriber config provides a RetryConfig, an error retries the message through the retry queues instead.
+//SubscribeWithDisposition behaves like Subscribe, but the handler is an implementation of the IDispositionHandler interface.
+//		The handler returns a Disposition (Ack, Nack, Reject, Retry or DeadLetter) which the subscriber honours exactly once per message.
+//SetMetrics registers an implementation of the IMetrics interface to which the subscriber reports how long messages wait for a worker and how long they take to handle.
+//SetOrderingKeyProvider registers an implementation of the IOrderingKeyProvider interface that chooses which messages must be handled in order.
+//RegisterCodec registers an implementation of the ICodec interface for its content type, adding to or replacing the codecs for JSON, Protobuf, MessagePack, CBOR, raw bytes and plain text.
+//		The publisher encodes payloads with the codec for the publisher config's ContentType. The subscriber decodes each message with the codec for the content type it was published with.
+//		Messages whose content type has no codec are dead-lettered without being passed to the handler.
+//		A subscriber without a DeadLetterConfig parks them if it has a RetryConfig, and otherwise nacks them according to RequeueOnNack, so that they are not silently discarded.
+//SetKeyProvider registers an implementation of the IKeyProvider interface, which turns on AES-GCM envelope encryption of message bodies.
+//		The publisher encrypts every body with the provider's current key, and records its id in the encryption.KeyIdHeader header.
+//		The subscriber decrypts every message with that header using the key with the recorded id, so keys can be rotated while older messages are still queued.
+//		Messages that cannot be decrypted are dead-lettered without being passed to the handler, so a subscriber must provide a DeadLetterConfig to decrypt messages.
+//Close provides a simple endpoint to close the channels and the connections from RabbitMQ.
 //		This call should, typically, be deferred immediately after calling a constructor.
+//		Subscribers should cancel the context passed to Subscribe and wait for it to return before calling Close, otherwise messages being handled will be redelivered.
 type IMessageBroker interface {
-	Publish(routingKey string, distributedMessage models.IDistributedMessage) error
-	Subscribe(handler processing.IMessageHandler, distributedMessage models.IDistributedMessage) error
+	Publish(routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) error
+	PublishWithContext(ctx context.Context, routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) error
+	PublishAsync(routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) (<-chan Confirmation, error)
+	SetReturnHandler(handler processing.IReturnHandler) error
+	Subscribe(ctx context.Context, handler processing.IMessageHandler) error
+	SubscribeWithDisposition(ctx context.Context, handler processing.IDispositionHandler) error
+	SetMetrics(metrics metrics.IMetrics) error
+	SetOrderingKeyProvider(keyProvider processing.IOrderingKeyProvider) error
+	RegisterCodec(messageCodec codec.ICodec)
+	SetKeyProvider(keyProvider encryption.IKeyProvider) error
 	Close()
 }
 
 type messageBroker struct {
-	config     models.Config
-	subscriber *messageSubscriber
-	publisher  *messagePublisher
-	logger     logs.ILogger
-	connection *amqp.Connection
-	channel    *amqp.Channel
+	config               models.Config
+	subscriber           *messageSubscriber
+	publisher            *messagePublisher
+	logger               logs.ILogger
+	codecs               *codec.Registry
+	subscriberConnection *connectionManager
+	republishConnection  *connectionManager
+	publisherConnection  *connectionManager
 }
 
-//NewMessageSubscriber initializes a message broker with a given subscriber config.
+//NewSubscriber initializes a message broker with a given subscriber config.
 //		This abstracts away the details of how the connection to RabbitMQ is made and how the queues and exchanges are defined.
-//		This will not initialize a publisher. As a result, any attempts to publish a message after using this constructor will not succeed.
+//		This will not initialize a publisher. As a result, any attempts to publish a message after using this constructor will return ErrNotPublisher.
+//If the broker cannot be created, the error returned is a *ValidationError, *ConnectionError or *TopologyError so the caller can decide how to handle the failure.
 //It is imperative that any users of this defer a call to Close() therafter.
 //ILogger is some implementation of logs.ILogger.
 //		By using an interface, the user of this endpoint can inject any implementation of ILogger.
-func NewMessageSubscriber(rmqConfig models.Config, logger logs.ILogger) *messageBroker {
-	broker := messageBroker{
-		logger: logger,
-	}
-
-	err := rmqConfig.Validate()
-	if err != nil {
-		broker.logger.LogError(err, "Validation of the configuration failed")
-	}
-	broker.config = rmqConfig
-	err = broker.connect()
+func NewSubscriber(rmqConfig models.Config, logger logs.ILogger) (IMessageBroker, error) {
+	broker, err := newMessageBroker(rmqConfig, logger, true, false)
 	if err != nil {
-		broker.logger.LogError(err, "Failed to connect to RabbitMQ broker")
+		return nil, err
 	}
-	err = broker.createChannel()
-	if err != nil {
-		broker.logger.LogError(err, "Failed to create channel")
-	}
-
-	broker.subscriber = newMessageSubscriber(*rmqConfig.SubscriberConfig, broker.channel, logger)
-	return &broker
+	return broker, nil
 }
 
-//NewMessagePublisher initializes a message broker with a given publisher config.
+//NewPublisher initializes a message broker with a given publisher config.
 //		This abstracts away the details of how the connection to RabbitMQ is made and how the exchanges are defined.
-//		This will not initialize a subscriber. As a result, any attempts to subscribe to a queue after using this constructor will not succeed.
+//		This will not initialize a subscriber. As a result, any attempts to subscribe to a queue after using this constructor will return ErrNotSubscriber.
+//If the broker cannot be created, the error returned is a *ValidationError, *ConnectionError or *TopologyError so the caller can decide how to handle the failure.
 //It is imperative that any users of this defer a call to Close() therafter.
 //ILogger is some implementation of logs.ILogger.
 //		By using an interface, the user of this endpoint can inject any implementation of ILogger.
-func NewMessagePublisher(rmqConfig models.Config, logger logs.ILogger) *messageBroker {
-	broker := messageBroker{
-		logger: logger,
+func NewPublisher(rmqConfig models.Config, logger logs.ILogger) (IMessageBroker, error) {
+	broker, err := newMessageBroker(rmqConfig, logger, false, true)
+	if err != nil {
+		return nil, err
 	}
+	return broker, nil
+}
 
-	err := rmqConfig.Validate()
+//NewPublisherSubscriber initializes a messsage broker with a given config.
+//		This abstracts away the details of how the connection to RabbitMQ is made and how the queues and exchanges are defined.
+//		This constructor should only ever be used if a user of the service needs to consume messages from a queue and publish to an exchange.
+//			It won't always be the case, but this will typically be when a subscriber implements IMessageHandler and then publishes to an exchange from the HandleMessage function.
+//		Publishing and consuming use separate connections to RabbitMQ, each of which is recovered independently.
+//If the broker cannot be created, the error returned is a *ValidationError, *ConnectionError or *TopologyError so the caller can decide how to handle the failure.
+//It is imperative that any users of this defer a call to Close() therafter.
+//ILogger is some implementation of logs.ILogger.
+//		By using an interface, the user of this endpoint can inject any implementation of ILogger.
+func NewPublisherSubscriber(rmqConfig models.Config, logger logs.ILogger) (IMessageBroker, error) {
+	broker, err := newMessageBroker(rmqConfig, logger, true, true)
 	if err != nil {
-		broker.logger.LogError(err, "Validation of the configuration failed")
+		return nil, err
 	}
-	broker.config = rmqConfig
-	err = broker.connect()
+	return broker, nil
+}
+
+//NewMessageSubscriber initializes a message broker with a given subscriber config.
+//		Any failure is reported through logger.LogError and a nil broker is returned.
+//Deprecated: Use NewSubscriber, which returns the failure to the caller instead.
+func NewMessageSubscriber(rmqConfig models.Config, logger logs.ILogger) *messageBroker {
+	broker, err := newMessageBroker(rmqConfig, logger, true, false)
 	if err != nil {
-		broker.logger.LogError(err, "Failed to connect to RabbitMQ broker")
+		logger.LogError(err, "Failed to create RabbitMQ subscriber")
 	}
-	err = broker.createChannel()
+	return broker
+}
+
+//NewMessagePublisher initializes a message broker with a given publisher config.
+//		Any failure is reported through logger.LogError and a nil broker is returned.
+//Deprecated: Use NewPublisher, which returns the failure to the caller instead.
+func NewMessagePublisher(rmqConfig models.Config, logger logs.ILogger) *messageBroker {
+	broker, err := newMessageBroker(rmqConfig, logger, false, true)
 	if err != nil {
-		broker.logger.LogError(err, "Failed to create channel")
+		logger.LogError(err, "Failed to create RabbitMQ publisher")
 	}
-
-	broker.publisher = newMessagePublisher(*rmqConfig.PublisherConfig, broker.channel, logger)
-	return &broker
+	return broker
 }
 
 //NewMessagePublisherSubscriber initializes a messsage broker with a given config.
-//		This abstracts away the details of how the connection to RabbitMQ is made and how the queues and exchanges are defined.
-//		This constructor should only ever be used if a user of the service needs to consume messages from a queue and publish to an exchange.
-//			It won't always be the case, but this will typically be when a subscriber implements IMessageHandler and then publishes to an exchange from the HandleMessage function.
-//It is imperative that any users of this defer a call to Close() therafter.
-//ILogger is some implementation of logs.ILogger.
-//		By using an interface, the user of this endpoint can inject any implementation of ILogger.
+//		Any failure is reported through logger.LogError and a nil broker is returned.
+//Deprecated: Use NewPublisherSubscriber, which returns the failure to the caller instead.
 func NewMessagePublisherSubscriber(rmqConfig models.Config, logger logs.ILogger) *messageBroker {
-	broker := messageBroker{
-		logger: logger,
+	broker, err := newMessageBroker(rmqConfig, logger, true, true)
+	if err != nil {
+		logger.LogError(err, "Failed to create RabbitMQ publisher and subscriber")
 	}
+	return broker
+}
 
+func newMessageBroker(rmqConfig models.Config, logger logs.ILogger, isSubscriber bool, isPublisher bool) (*messageBroker, error) {
 	err := rmqConfig.Validate()
 	if err != nil {
-		broker.logger.LogError(err, "Validation of the configuration failed")
+		return nil, &ValidationError{Err: err}
 	}
-	broker.config = rmqConfig
-	err = broker.connect()
-	if err != nil {
-		broker.logger.LogError(err, "Failed to connect to RabbitMQ broker")
+	if isSubscriber && rmqConfig.SubscriberConfig == nil {
+		return nil, &ValidationError{Err: errors.New("subscriberConfig is missing. A subscriber must provide a subscriberConfig")}
 	}
-	err = broker.createChannel()
-	if err != nil {
-		broker.logger.LogError(err, "Failed to create channel")
+	if isPublisher && rmqConfig.PublisherConfig == nil {
+		return nil, &ValidationError{Err: errors.New("publisherConfig is missing. A publisher must provide a publisherConfig")}
+	}
+
+	broker := messageBroker{
+		config: rmqConfig,
+		logger: logger,
+		codecs: codec.NewDefaultRegistry(),
 	}
 
-	broker.subscriber = newMessageSubscriber(*rmqConfig.SubscriberConfig, broker.channel, logger)
-	broker.publisher = newMessagePublisher(*rmqConfig.PublisherConfig, broker.channel, logger)
-	return &broker
+	//Publishing and consuming use separate connections, so that a publisher blocked by flow control cannot stall acknowledgements
+	//and a channel exception caused by a bad publish cannot stop consumption.
+	if isSubscriber {
+		broker.subscriberConnection, err = newConnectionManager(rmqConfig, "consuming", logger)
+		if err != nil {
+			return nil, err
+		}
+		//Retried messages are republished on a connection of their own, so that RabbitMQ blocking it during an alarm cannot stall acknowledgements either.
+		if rmqConfig.SubscriberConfig.RetryConfig != nil {
+			broker.republishConnection, err = newConnectionManager(rmqConfig, "republishing", logger)
+			if err != nil {
+				broker.Close()
+				return nil, err
+			}
+		}
+		broker.subscriber, err = newMessageSubscriber(*rmqConfig.SubscriberConfig, broker.subscriberConnection, broker.republishConnection, broker.codecs, logger)
+		if err != nil {
+			broker.Close()
+			return nil, err
+		}
+	}
+	if isPublisher {
+		broker.publisherConnection, err = newConnectionManager(rmqConfig, "publishing", logger)
+		if err != nil {
+			broker.Close()
+			return nil, err
+		}
+		broker.publisher, err = newMessagePublisher(*rmqConfig.PublisherConfig, broker.publisherConnection, broker.codecs, logger)
+		if err != nil {
+			broker.Close()
+			return nil, err
+		}
+	}
+
+	return &broker, nil
 }
 
 //Subscribe provides an endpoint for users who wish to consume distributed messages.
 //The implementation of IMessageHandler must know how to convert a DistributedMessage into their desired struct in order to process the message correctly.
-//The message handler's "HandleMessage" function will be called on demand and asynchronously.
-func (broker *messageBroker) Subscribe(handler processing.IMessageHandler) error {
+//The message handler's "HandleMessage" function will be called on demand and asynchronously, by at most Concurrency workers at a time.
+//Subscribe blocks until the context is cancelled or the broker is closed.
+//		On cancellation it stops consuming, waits up to the subscriber config's shutdown timeout for messages being handled to finish, and returns.
+//		ErrShutdownTimeout is returned if messages were still being handled when the timeout elapsed.
+func (broker *messageBroker) Subscribe(ctx context.Context, handler processing.IMessageHandler) error {
+	return broker.subscribe(ctx, handler, decodeAny)
+}
+
+//subscribe adapts the message handler to the subscriber's retry and requeue settings and consumes with it, decoding payloads with decodeData.
+func (broker *messageBroker) subscribe(ctx context.Context, handler processing.IMessageHandler, decodeData dataDecoder) error {
 	if broker.subscriber == nil {
-		broker.logger.LogError(nil, "RabbitMQ broker was not setup as a subscriber. Cannot subscribe...")
+		return ErrNotSubscriber
+	}
+	if broker.config.SubscriberConfig.RetryConfig != nil {
+		return broker.subscriber.subscribe(ctx, processing.AdaptMessageHandlerWithRetry(handler), decodeData)
 	}
-	return broker.subscriber.subscribe(handler)
+	return broker.subscriber.subscribe(ctx, processing.AdaptMessageHandler(handler, broker.config.SubscriberConfig.RequeueOnNack), decodeData)
 }
 
-//Publish exposes an endpoint for any users who intend to publish a message.
-//Any message that is published to RabbitMQ must satisfy the requirements of the IDistributedMessage interface.
-//Any further interfaces that extend the contract of IDistributedMessage can be added at the will of the user.
-func (broker *messageBroker) Publish(routingKey string, distributedMessage models.IDistributedMessage) error {
-	if broker.publisher == nil {
-		broker.logger.LogError(nil, "RabbitMQ broker was not setup as a publisher. Cannot publish...")
+//SubscribeWithDisposition provides an endpoint for users who wish to consume distributed messages and decide exactly what happens to each of them.
+//The disposition handler's "HandleDelivery" function will be called in the same way as Subscribe calls "HandleMessage".
+//Each message is settled exactly once according to the Disposition the handler returns.
+//		Messages that cannot be decoded are dead-lettered without being passed to the handler, or parked or nacked if the subscriber has no DeadLetterConfig.
+func (broker *messageBroker) SubscribeWithDisposition(ctx context.Context, handler processing.IDispositionHandler) error {
+	return broker.subscribeWithDisposition(ctx, handler, decodeAny)
+}
+
+//subscribeWithDisposition consumes with the disposition handler, decoding payloads with decodeData.
+func (broker *messageBroker) subscribeWithDisposition(ctx context.Context, handler processing.IDispositionHandler, decodeData dataDecoder) error {
+	if broker.subscriber == nil {
+		return ErrNotSubscriber
 	}
-	return broker.publisher.publish(routingKey, distributedMessage)
+	return broker.subscriber.subscribe(ctx, handler, decodeData)
 }
 
-//Close closes the connection to the RabbitMQ broker.
-//		Close will handle the broker's channel destruction and the connection destruction.
-//		Call this function as a deffered execution after creating a connection to RabbitMQ.
-func (broker *messageBroker) Close() {
-	broker.channel.Close()
-	broker.connection.Close()
+//SetMetrics exposes an endpoint for subscribers who want to measure how messages flow through the worker pool.
+//		SetMetrics must be called before Subscribe.
+func (broker *messageBroker) SetMetrics(metrics metrics.IMetrics) error {
+	if broker.subscriber == nil {
+		return ErrNotSubscriber
+	}
+	broker.subscriber.setMetrics(metrics)
+	return nil
+}
+
+//SetOrderingKeyProvider exposes an endpoint for subscribers who need messages about the same entity to be handled in order.
+//		Registering a key provider enables ordered processing, even if the subscriber config does not provide an OrderedProcessing configuration.
+//		SetOrderingKeyProvider must be called before Subscribe.
+func (broker *messageBroker) SetOrderingKeyProvider(keyProvider processing.IOrderingKeyProvider) error {
+	if broker.subscriber == nil {
+		return ErrNotSubscriber
+	}
+	broker.subscriber.setOrderingKeyProvider(keyProvider)
+	return nil
 }
 
-func (broker *messageBroker) connect() error {
-	connectionString := fmt.Sprintf(
-		rmqConnectionStringTemplate,
-		broker.config.Username,
-		broker.config.Password,
-		broker.config.RabbitMqHost,
-		broker.config.VirtualHost)
+//RegisterCodec exposes an endpoint for users who publish or consume payloads in an encoding other than the built-in ones, or who want to replace how a built-in one is encoded.
+//		The codec is shared by the publisher and subscriber, and is registered for the content type it returns.
+//		RegisterCodec must be called before Subscribe or Publish.
+func (broker *messageBroker) RegisterCodec(messageCodec codec.ICodec) {
+	broker.codecs.Register(messageCodec)
+}
 
-	connection, err := amqp.Dial(connectionString)
-	if err != nil {
-		return err
+//SetKeyProvider exposes an endpoint for users whose messages carry sensitive data that must stay encrypted while it sits in queues.
+//		Only the body is encrypted. Headers, including CloudEvents attributes in binary mode, are not.
+//		Subscribers treat messages without the encryption.KeyIdHeader header as plain text, so publishers can start encrypting before every subscriber has been given the keys.
+//		Messages that cannot be decrypted are rejected so that RabbitMQ routes them to the dead-letter exchange. Without one they would be lost,
+//		so a *ValidationError is returned, and the key provider is not registered, if the broker subscribes without a DeadLetterConfig.
+//		SetKeyProvider must be called before Subscribe or Publish.
+func (broker *messageBroker) SetKeyProvider(keyProvider encryption.IKeyProvider) error {
+	if broker.subscriber != nil && broker.subscriber.config.DeadLetterConfig == nil {
+		return &ValidationError{Err: errors.New("subscriberConfig.deadLetterConfig is missing. Messages that cannot be decrypted are dead-lettered, so a subscriber must provide a deadLetterConfig to decrypt messages")}
 	}
 
-	broker.connection = connection
+	if broker.subscriber != nil {
+		broker.subscriber.setEncryptionKeys(keyProvider)
+	}
+	if broker.publisher != nil {
+		broker.publisher.setEncryptionKeys(keyProvider)
+	}
 	return nil
 }
 
-func (broker *messageBroker) createChannel() error {
-	channel, err := broker.connection.Channel()
-	if err != nil {
-		return err
+//Publish exposes an endpoint for any users who intend to publish a message.
+//Any message that is published to RabbitMQ must satisfy the requirements of the IDistributedMessage interface.
+//Any further interfaces that extend the contract of IDistributedMessage can be added at the will of the user.
+//Publish is the same as PublishWithContext with a background context, so it can wait indefinitely while RabbitMQ has blocked the connection.
+func (broker *messageBroker) Publish(routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) error {
+	return broker.PublishWithContext(context.Background(), routingKey, distributedMessage, options...)
+}
+
+//PublishWithContext exposes an endpoint for users who intend to publish a message and need to bound how long publishing may take.
+//The context's cancellation and deadline are honoured while waiting for RabbitMQ to unblock the connection, for a free channel and, in confirm mode, for the confirmation.
+//		While RabbitMQ has blocked the connection because of a memory or disk alarm, the publisher config's BlockedPolicy decides whether to wait or fail with ErrConnectionBlocked.
+func (broker *messageBroker) PublishWithContext(ctx context.Context, routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) error {
+	if broker.publisher == nil {
+		return ErrNotPublisher
 	}
+	return broker.publisher.publish(ctx, routingKey, distributedMessage, options)
+}
 
-	broker.channel = channel
+//PublishAsync exposes an endpoint for publishers with confirm mode enabled that do not want to wait for each message to be confirmed.
+//The returned channel receives a single Confirmation for the message. Callers publishing at high throughput can collect these in the background.
+//Returns ErrConfirmModeDisabled if the publisher config does not enable confirm mode.
+func (broker *messageBroker) PublishAsync(routingKey string, distributedMessage models.IDistributedMessage, options ...PublishOption) (<-chan Confirmation, error) {
+	if broker.publisher == nil {
+		return nil, ErrNotPublisher
+	}
+	return broker.publisher.publishAsync(context.Background(), routingKey, distributedMessage, options)
+}
+
+//SetReturnHandler exposes an endpoint for publishers that want to handle messages RabbitMQ returns as unroutable.
+//Returned messages are only reported when MandatoryQueueBind is set in the publisher config.
+//Without a return handler, returned messages are logged as warnings (or, in confirm mode, reported through Publish).
+func (broker *messageBroker) SetReturnHandler(handler processing.IReturnHandler) error {
+	if broker.publisher == nil {
+		return ErrNotPublisher
+	}
+	broker.publisher.setReturnHandler(handler)
 	return nil
 }
+
+//Close closes the connections to the RabbitMQ broker.
+//		Close will handle the broker's channel destruction and the connection destruction, for the publishing and the consuming connection, and the connection retried messages are republished on.
+//		Close will also stop any reconnection that is in progress, and release the decoders used to decompress messages.
+//		Call this function as a deffered execution after creating a connection to RabbitMQ.
+func (broker *messageBroker) Close() {
+	if broker.subscriberConnection != nil {
+		broker.subscriberConnection.close()
+	}
+	if broker.republishConnection != nil {
+		broker.republishConnection.close()
+	}
+	if broker.publisherConnection != nil {
+		broker.publisherConnection.close()
+	}
+	if broker.subscriber != nil {
+		broker.subscriber.close()
+	}
+	if broker.publisher != nil {
+		broker.publisher.close()
+	}
+}
@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/bindingType"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+)
+
+func newTestBrokerConfig(subscriberConfig *models.SubscriberConfig, publisherConfig *models.PublisherConfig) models.Config {
+	return models.Config{
+		Username:         "test",
+		Password:         "test",
+		RabbitMqHost:     "localhost",
+		VirtualHost:      "/",
+		SubscriberConfig: subscriberConfig,
+		PublisherConfig:  publisherConfig,
+	}
+}
+
+func TestConstructors_GivenInvalidConfig_ShouldReturnValidationError(t *testing.T) {
+	subscriberConfig := &models.SubscriberConfig{
+		QueueName:     "test",
+		ExchangeName:  "test",
+		BindingType:   bindingType.Topic,
+		RoutingKey:    "test.*",
+		PrefetchCount: 100,
+	}
+	publisherConfig := &models.PublisherConfig{
+		ExchangeName: "test",
+		BindingType:  bindingType.Fanout,
+	}
+	withoutUsername := newTestBrokerConfig(subscriberConfig, publisherConfig)
+	withoutUsername.Username = ""
+	withoutExchange := newTestBrokerConfig(nil, &models.PublisherConfig{BindingType: bindingType.Fanout})
+
+	tests := []struct {
+		name      string
+		construct func(rmqConfig models.Config, logger logs.ILogger) (IMessageBroker, error)
+		config    models.Config
+		reason    string
+	}{
+		{"NewSubscriber without subscriberConfig", NewSubscriber, newTestBrokerConfig(nil, publisherConfig), "subscriberConfig is missing"},
+		{"NewPublisher without publisherConfig", NewPublisher, newTestBrokerConfig(subscriberConfig, nil), "publisherConfig is missing"},
+		{"NewPublisherSubscriber without subscriberConfig", NewPublisherSubscriber, newTestBrokerConfig(nil, publisherConfig), "subscriberConfig is missing"},
+		{"NewPublisherSubscriber without publisherConfig", NewPublisherSubscriber, newTestBrokerConfig(subscriberConfig, nil), "publisherConfig is missing"},
+		{"NewSubscriber with invalid config", NewSubscriber, withoutUsername, "username is empty string"},
+		{"NewPublisher with invalid publisherConfig", NewPublisher, withoutExchange, "publisherConfig.exchangeName"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Act
+			rmqBroker, err := test.construct(test.config, logs.Logger{})
+
+			// Assert
+			var validationErr *ValidationError
+			assert.Nil(t, rmqBroker)
+			assert.True(t, errors.As(err, &validationErr))
+			assert.Contains(t, validationErr.Err.Error(), test.reason)
+		})
+	}
+}
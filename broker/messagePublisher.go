@@ -1,73 +1,341 @@
 package broker
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/KrylixZA/GoRabbitMqBroker/blockedPolicy"
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/encryption"
 	"github.com/KrylixZA/GoRabbitMqBroker/logs"
 	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
 	"github.com/streadway/amqp"
 )
 
+const returnBufferSize = 128
+
 type messagePublisher struct {
-	config  models.PublisherConfig
-	channel *amqp.Channel
-	logger  logs.ILogger
+	config         models.PublisherConfig
+	connection     *connectionManager
+	pool           *channelPool
+	codecs         *codec.Registry
+	encryptionKeys encryption.IKeyProvider
+	logger         logs.ILogger
+	mutex          sync.Mutex
+	returnHandler  processing.IReturnHandler
+	decompressor   decompressor
 }
 
-func newMessagePublisher(config models.PublisherConfig, channel *amqp.Channel, logger logs.ILogger) *messagePublisher {
+func newMessagePublisher(config models.PublisherConfig, connection *connectionManager, codecs *codec.Registry, logger logs.ILogger) (*messagePublisher, error) {
 	publisher := messagePublisher{
-		config:  config,
-		channel: channel,
-		logger:  logger,
+		config:     config,
+		connection: connection,
+		codecs:     codecs,
+		logger:     logger,
+	}
+	publisher.pool = newChannelPool(config.PoolSize(), connection.currentConnection, publisher.openChannel)
+
+	err := connection.addTopology(publisher.declare)
+	if err != nil {
+		return nil, err
 	}
 
-	//Declare the exchange
+	return &publisher, nil
+}
+
+//declare declares the exchange the publisher publishes to.
+//It is replayed after every reconnect.
+func (publisher *messagePublisher) declare(channel *amqp.Channel) error {
 	err := channel.ExchangeDeclare(
-		config.ExchangeName,
-		config.BindingType.String(),
-		config.Durable,
+		publisher.config.ExchangeName,
+		publisher.config.BindingType.String(),
+		publisher.config.Durable,
 		false,
 		false,
 		false,
 		nil)
 	if err != nil {
-		panic(err)
+		return &TopologyError{Entity: "exchange", Name: publisher.config.ExchangeName, Err: err}
 	}
 
-	return &publisher
+	return nil
 }
 
-func (publisher *messagePublisher) publish(routingKey string, distributedMessage models.IDistributedMessage) error {
-	distributedMessageJSONPayload, err := json.Marshal(distributedMessage.GetData())
+//openChannel opens a channel for the channel pool and, if configured, puts it into confirm mode and listens for returned messages on it.
+func (publisher *messagePublisher) openChannel(connection *amqp.Connection) (*pooledChannel, error) {
+	channel, err := connection.Channel()
 	if err != nil {
-		publisher.logger.LogWarning(fmt.Sprintf("Error occurred while creating JSON payload from distributedMessage %s\n\n%s",
-			distributedMessage,
-			err))
+		return nil, &ConnectionError{Err: err}
+	}
+	leased := pooledChannel{
+		channel:    channel,
+		connection: connection,
+		closed:     channel.NotifyClose(make(chan *amqp.Error, 1)),
 	}
 
-	publishParams := amqp.Publishing{
-		DeliveryMode:  amqp.Persistent,
-		ContentType:   "text/json",
-		CorrelationId: distributedMessage.GetCorrelationId(),
-		MessageId:     distributedMessage.GetMessageId(),
-		Timestamp:     distributedMessage.GetTimestamp(),
-		Body:          distributedMessageJSONPayload,
+	var returns <-chan amqp.Return
+	if publisher.config.MandatoryQueueBind {
+		returns = channel.NotifyReturn(make(chan amqp.Return, returnBufferSize))
 	}
-	err = publisher.channel.Publish(
+
+	if publisher.config.ConfirmMode {
+		leased.tracker, err = newConfirmTracker(channel, returns, publisher.handleReturn)
+		if err != nil {
+			channel.Close()
+			return nil, &TopologyError{Entity: "confirm mode for exchange", Name: publisher.config.ExchangeName, Err: err}
+		}
+	} else if returns != nil {
+		go publisher.listenForReturns(returns)
+	}
+
+	return &leased, nil
+}
+
+//close releases the resources the publisher holds on to outside of its connection. Returned messages can no longer be decompressed with zstd afterwards.
+func (publisher *messagePublisher) close() {
+	publisher.decompressor.close()
+}
+
+//setReturnHandler registers the handler that is told about messages RabbitMQ returns as unroutable.
+func (publisher *messagePublisher) setReturnHandler(handler processing.IReturnHandler) {
+	publisher.mutex.Lock()
+	publisher.returnHandler = handler
+	publisher.mutex.Unlock()
+}
+
+//setEncryptionKeys sets the key provider message bodies are encrypted with. It must be called before publish.
+func (publisher *messagePublisher) setEncryptionKeys(encryptionKeys encryption.IKeyProvider) {
+	publisher.encryptionKeys = encryptionKeys
+}
+
+//listenForReturns passes returned messages to the return handler until the channel closes. It is only used outside of confirm mode.
+//		The handler is called on a goroutine of its own, so that a slow handler does not hold up the AMQP library's connection reader.
+func (publisher *messagePublisher) listenForReturns(returns <-chan amqp.Return) {
+	queue := newReturnQueue(publisher.handleReturn)
+	for returned := range returns {
+		queue.push(returned, nil)
+	}
+	queue.close()
+}
+
+//handleReturn passes a returned message to the return handler.
+//		If the original message is not known, it is rebuilt from the returned body and properties.
+func (publisher *messagePublisher) handleReturn(returned amqp.Return, distributedMessage models.IDistributedMessage) {
+	publisher.mutex.Lock()
+	handler := publisher.returnHandler
+	publisher.mutex.Unlock()
+
+	if handler == nil {
+		if !publisher.config.ConfirmMode {
+			publisher.logger.LogWarning(fmt.Sprintf("RabbitMQ returned message with messageId=%s published to exchange=%s with routing key=%s as unroutable: %d %s",
+				returned.MessageId,
+				returned.Exchange,
+				returned.RoutingKey,
+				returned.ReplyCode,
+				returned.ReplyText))
+		}
+		return
+	}
+
+	if distributedMessage == nil {
+		message := models.DistributedMessage{
+			Timestamp:     returned.Timestamp,
+			MessageId:     returned.MessageId,
+			CorrelationId: returned.CorrelationId,
+		}
+		message.Headers = messageHeaders(returned.Headers)
+		//The returned body was compressed by this publisher, so the default limit of a subscriber applies.
+		decoder := bodyDecoder{
+			codecs:               publisher.codecs,
+			encryptionKeys:       publisher.encryptionKeys,
+			decodeData:           decodeAny,
+			decompressor:         &publisher.decompressor,
+			maxDecompressedBytes: models.SubscriberConfig{}.DecompressionLimit(),
+		}
+		err := decoder.decode(returned.ContentType, returned.ContentEncoding, returned.Headers, returned.Body, &message)
+		if err != nil {
+			publisher.logger.LogWarning(fmt.Sprintf("Error occurred while trying to parse returned message to DistributedMessage struct\n\n%s",
+				err))
+		}
+		distributedMessage = message
+	}
+
+	handler.HandleReturn(models.ReturnedMessage{
+		ReplyCode:  returned.ReplyCode,
+		ReplyText:  returned.ReplyText,
+		Exchange:   returned.Exchange,
+		RoutingKey: returned.RoutingKey,
+		Message:    distributedMessage,
+	})
+}
+
+//publish publishes the message and, in confirm mode, waits for RabbitMQ to confirm it.
+//		The context bounds how long the publish waits for a blocked connection to be unblocked, for a free channel and for the confirmation.
+func (publisher *messagePublisher) publish(ctx context.Context, routingKey string, distributedMessage models.IDistributedMessage, options []PublishOption) error {
+	if publisher.config.ConfirmMode {
+		confirmation, err := publisher.publishAsync(ctx, routingKey, distributedMessage, options)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case result := <-confirmation:
+			return result.Err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(publisher.config.ConfirmTimeout()):
+			return ErrConfirmTimeout
+		}
+	}
+
+	publishParams, err := publisher.newPublishing(distributedMessage, options)
+	if err != nil {
+		return err
+	}
+
+	err = publisher.awaitPublishable(ctx)
+	if err != nil {
+		return err
+	}
+	leased, err := publisher.pool.lease(ctx)
+	if err != nil {
+		return err
+	}
+	defer publisher.pool.release(leased)
+
+	err = leased.channel.Publish(
 		publisher.config.ExchangeName,
 		routingKey,
 		publisher.config.MandatoryQueueBind,
 		false,
 		publishParams)
+	if err != nil {
+		publisher.logPublishFailure(routingKey, publishParams, err)
+		return err
+	}
+
+	return nil
+}
+
+//publishAsync publishes the message in confirm mode and returns a channel on which RabbitMQ's confirmation will be delivered.
+//		The context bounds how long the publish waits for a blocked connection to be unblocked and for a free channel.
+func (publisher *messagePublisher) publishAsync(ctx context.Context, routingKey string, distributedMessage models.IDistributedMessage, options []PublishOption) (<-chan Confirmation, error) {
+	if !publisher.config.ConfirmMode {
+		return nil, ErrConfirmModeDisabled
+	}
+
+	publishParams, err := publisher.newPublishing(distributedMessage, options)
+	if err != nil {
+		return nil, err
+	}
+
+	err = publisher.awaitPublishable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leased, err := publisher.pool.lease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer publisher.pool.release(leased)
+
+	confirmation, err := leased.tracker.publish(
+		publisher.config.ExchangeName,
+		routingKey,
+		publisher.config.MandatoryQueueBind,
+		publishParams,
+		distributedMessage)
+	if err != nil {
+		publisher.logPublishFailure(routingKey, publishParams, err)
+		return nil, err
+	}
+
+	return confirmation, nil
+}
 
+//awaitPublishable applies the blocked policy when RabbitMQ has blocked the connection.
+//		It either fails with ErrConnectionBlocked straight away, or waits until the connection is unblocked or the context is done.
+func (publisher *messagePublisher) awaitPublishable(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if publisher.config.BlockedPolicy == blockedPolicy.FailFast {
+		if publisher.connection.isBlocked() {
+			return ErrConnectionBlocked
+		}
+		return nil
+	}
+	return publisher.connection.awaitUnblocked(ctx)
+}
+
+//newPublishing builds the AMQP publishing for the message, then applies the publish options to it.
+//		The payload is encoded by the codec registered for the publisher config's content type, which the message is labelled with.
+//		If the codec records the type of the payload, e.g. Protobuf, the type is recorded in the codec.TypeHeader header.
+//		If the publisher config enables CloudEvents, the message is turned into a CloudEvent last, so that the event reflects the publish options.
+//		If the publisher config enables compression, the body is compressed after that, so that a structured CloudEvent is compressed as a whole.
+//		If a key provider is set, the body is encrypted last, as encrypted bodies cannot be compressed.
+func (publisher *messagePublisher) newPublishing(distributedMessage models.IDistributedMessage, options []PublishOption) (amqp.Publishing, error) {
+	contentType := publisher.config.PublishedContentType()
+	messageCodec, err := publisher.codecs.Lookup(contentType)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	body, err := messageCodec.Marshal(distributedMessage.GetData())
 	if err != nil {
-		publisher.logger.LogWarning(fmt.Sprintf("Error occurred while publishing args=%+v to exchange=%s with routing key=%s\n\n%s",
-			publishParams,
-			publisher.config.ExchangeName,
-			routingKey,
+		publisher.logger.LogWarning(fmt.Sprintf("Error occurred while creating %s payload from distributedMessage %s\n\n%s",
+			contentType,
+			distributedMessage,
 			err))
+		return amqp.Publishing{}, err
 	}
 
-	return nil
+	publishing := amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   contentType,
+		CorrelationId: distributedMessage.GetCorrelationId(),
+		MessageId:     distributedMessage.GetMessageId(),
+		Timestamp:     distributedMessage.GetTimestamp(),
+		Body:          body,
+	}
+	if typeRecordingCodec, ok := messageCodec.(codec.ITypeRecordingCodec); ok {
+		typeName, err := typeRecordingCodec.TypeName(distributedMessage.GetData())
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+		publishing.Headers = amqp.Table{codec.TypeHeader: typeName}
+	}
+	for _, option := range options {
+		option(&publishing)
+	}
+	if publisher.config.CloudEvents != nil {
+		err = applyCloudEvent(*publisher.config.CloudEvents, &publishing)
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	if publisher.config.CompressionConfig != nil {
+		err = applyCompression(*publisher.config.CompressionConfig, &publishing)
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	if publisher.encryptionKeys != nil {
+		err = applyEncryption(publisher.encryptionKeys, &publishing)
+		if err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	return publishing, nil
+}
+
+func (publisher *messagePublisher) logPublishFailure(routingKey string, publishParams amqp.Publishing, err error) {
+	publisher.logger.LogWarning(fmt.Sprintf("Error occurred while publishing args=%+v to exchange=%s with routing key=%s\n\n%s",
+		publishParams,
+		publisher.config.ExchangeName,
+		routingKey,
+		err))
 }
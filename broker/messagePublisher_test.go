@@ -0,0 +1,169 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/blockedPolicy"
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//newBlockedTestPublisher returns a publisher whose connection RabbitMQ has blocked.
+func newBlockedTestPublisher(policy blockedPolicy.BlockedPolicy) (*messagePublisher, chan amqp.Blocking) {
+	manager := &connectionManager{logger: logs.Logger{}, done: make(chan struct{})}
+	blockings := make(chan amqp.Blocking, 1)
+	go manager.watchBlocked(blockings)
+	blockings <- amqp.Blocking{Active: true, Reason: "low on memory"}
+	for !manager.isBlocked() {
+		time.Sleep(time.Millisecond)
+	}
+
+	publisher := &messagePublisher{
+		config:     models.PublisherConfig{BlockedPolicy: policy},
+		connection: manager,
+	}
+	return publisher, blockings
+}
+
+func TestAwaitPublishable_GivenBlockedConnectionAndFailFastPolicy_ShouldReturnErrConnectionBlocked(t *testing.T) {
+	// Arrange
+	publisher, blockings := newBlockedTestPublisher(blockedPolicy.FailFast)
+	defer close(blockings)
+
+	// Act
+	err := publisher.awaitPublishable(context.Background())
+
+	// Assert
+	assert.Equal(t, ErrConnectionBlocked, err)
+}
+
+func TestAwaitPublishable_GivenBlockedConnectionAndWaitPolicy_ShouldReturnContextErrorAtDeadline(t *testing.T) {
+	// Arrange
+	publisher, blockings := newBlockedTestPublisher(blockedPolicy.Wait)
+	defer close(blockings)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := publisher.awaitPublishable(ctx)
+
+	// Assert
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestAwaitPublishable_GivenWaitPolicy_ShouldReturnOnceConnectionIsUnblocked(t *testing.T) {
+	// Arrange
+	publisher, blockings := newBlockedTestPublisher(blockedPolicy.Wait)
+	defer close(blockings)
+
+	// Act
+	result := make(chan error, 1)
+	go func() {
+		result <- publisher.awaitPublishable(context.Background())
+	}()
+	blockings <- amqp.Blocking{Active: false}
+
+	// Assert
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the publish was never released after the connection was unblocked")
+	}
+}
+
+//recordingReturnHandler passes every returned message to the test.
+type recordingReturnHandler struct {
+	returned chan models.ReturnedMessage
+}
+
+func (handler recordingReturnHandler) HandleReturn(returnedMessage models.ReturnedMessage) {
+	handler.returned <- returnedMessage
+}
+
+//awaitReturn waits for a returned message, failing the test if it never arrives.
+func awaitReturn(t *testing.T, handler recordingReturnHandler) models.ReturnedMessage {
+	select {
+	case returnedMessage := <-handler.returned:
+		return returnedMessage
+	case <-time.After(5 * time.Second):
+		t.Fatal("the return handler was never called")
+		return models.ReturnedMessage{}
+	}
+}
+
+func TestHandleReturn_GivenConfirmMode_ShouldPassPublishedMessageToReturnHandler(t *testing.T) {
+	// Arrange
+	handler := recordingReturnHandler{returned: make(chan models.ReturnedMessage, 1)}
+	publisher := &messagePublisher{
+		config: models.PublisherConfig{ConfirmMode: true, MandatoryQueueBind: true},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	publisher.setReturnHandler(handler)
+	tracker, channel, confirmations, returns := newTestConfirmTracker(publisher.handleReturn)
+	message := models.DistributedMessage{MessageId: "unroutable", Data: map[string]interface{}{"id": "1"}}
+	confirmation, _ := tracker.publish("orders", "created", true, amqp.Publishing{MessageId: "unroutable"}, message)
+
+	// Act
+	returns <- amqp.Return{
+		ReplyCode:  amqp.NoRoute,
+		ReplyText:  "NO_ROUTE",
+		Exchange:   "orders",
+		RoutingKey: "created",
+		MessageId:  "unroutable",
+		Headers:    channel.published[0].Headers,
+	}
+	confirmations <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+	// Assert
+	returnedMessage := awaitReturn(t, handler)
+	assert.Equal(t, uint16(amqp.NoRoute), returnedMessage.ReplyCode)
+	assert.Equal(t, "orders", returnedMessage.Exchange)
+	assert.Equal(t, "created", returnedMessage.RoutingKey)
+	assert.Equal(t, message, returnedMessage.Message)
+	var unroutableErr *UnroutableError
+	assert.ErrorAs(t, awaitConfirmation(t, confirmation).Err, &unroutableErr)
+}
+
+func TestHandleReturn_GivenNoConfirmMode_ShouldRebuildMessageWithoutPublishSequenceHeader(t *testing.T) {
+	// Arrange
+	handler := recordingReturnHandler{returned: make(chan models.ReturnedMessage, 1)}
+	publisher := &messagePublisher{
+		config: models.PublisherConfig{MandatoryQueueBind: true},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	publisher.setReturnHandler(handler)
+	returns := make(chan amqp.Return, 1)
+	go publisher.listenForReturns(returns)
+
+	// Act
+	returns <- amqp.Return{
+		ReplyCode:     amqp.NoRoute,
+		ReplyText:     "NO_ROUTE",
+		Exchange:      "orders",
+		RoutingKey:    "created",
+		MessageId:     "unroutable",
+		CorrelationId: "correlation",
+		ContentType:   "application/json",
+		Headers:       amqp.Table{"tenant": "acme", publishSequenceHeader: int64(7)},
+		Body:          []byte(`{"id":"1"}`),
+	}
+	close(returns)
+
+	// Assert
+	returnedMessage := awaitReturn(t, handler)
+	rebuilt, ok := returnedMessage.Message.(models.DistributedMessage)
+	assert.True(t, ok)
+	assert.Equal(t, "unroutable", rebuilt.MessageId)
+	assert.Equal(t, "correlation", rebuilt.CorrelationId)
+	assert.Equal(t, map[string]interface{}{"id": "1"}, rebuilt.Data)
+	assert.Equal(t, map[string]interface{}{"tenant": "acme"}, rebuilt.Headers)
+}
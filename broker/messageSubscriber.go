@@ -1,30 +1,143 @@
 package broker
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/encryption"
 	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/metrics"
 	"github.com/KrylixZA/GoRabbitMqBroker/models"
 	"github.com/KrylixZA/GoRabbitMqBroker/processing"
 	"github.com/streadway/amqp"
 )
 
+//deliveryCountHeader is the header quorum queues count the previous deliveries of a message in.
+const deliveryCountHeader = "x-delivery-count"
+
+var consumerSequence uint64
+
+//dataDecoder decodes the body of a consumed message into the payload handed to the handler, using the codec for the message's content type.
+type dataDecoder func(messageCodec codec.ICodec, body []byte, headers amqp.Table) (interface{}, error)
+
+//decodeAny decodes the body into an interface{}, leaving the codec to choose the Go type of the payload, e.g. a map[string]interface{} for a JSON object.
+//		Codecs that record the type of the payload decode it into a new payload of the type named by the codec.TypeHeader header instead.
+func decodeAny(messageCodec codec.ICodec, body []byte, headers amqp.Table) (interface{}, error) {
+	if typeRecordingCodec, ok := messageCodec.(codec.ITypeRecordingCodec); ok {
+		typeName, _ := headers[codec.TypeHeader].(string)
+		payload, err := typeRecordingCodec.NewPayload(typeName)
+		if err != nil {
+			return nil, err
+		}
+		err = messageCodec.Unmarshal(body, payload)
+		return payload, err
+	}
+
+	var data interface{}
+	err := messageCodec.Unmarshal(body, &data)
+	return data, err
+}
+
+//amqpConsumer is the part of an *amqp.Channel that a consumer is cancelled on.
+type amqpConsumer interface {
+	Cancel(consumer string, noWait bool) error
+}
+
+//lookupCodec returns the codec registered for the content type.
+//		Messages without a content type are decoded as JSON, which is how they were always published before codecs could be chosen.
+func lookupCodec(codecs *codec.Registry, contentType string) (codec.ICodec, error) {
+	if contentType == "" {
+		return codec.JSON{}, nil
+	}
+	return codecs.Lookup(contentType)
+}
+
 type messageSubscriber struct {
-	config  models.SubscriberConfig
-	channel *amqp.Channel
-	queue   amqp.Queue
-	logger  logs.ILogger
+	config              models.SubscriberConfig
+	connection          *connectionManager
+	republishConnection *connectionManager
+	mutex               sync.Mutex
+	queueName           string
+	logger              logs.ILogger
+	metrics             metrics.IMetrics
+	keyProvider         processing.IOrderingKeyProvider
+	codecs              *codec.Registry
+	encryptionKeys      encryption.IKeyProvider
+	republishMutex      sync.Mutex
+	republishing        *pooledChannel
+	decompressor        decompressor
 }
 
-func newMessageSubscriber(config models.SubscriberConfig, channel *amqp.Channel, logger logs.ILogger) *messageSubscriber {
+//newMessageSubscriber declares the subscriber's topology on the consuming connection.
+//		republishConnection is the connection retried messages are republished on. It is only needed when the config provides a RetryConfig.
+func newMessageSubscriber(config models.SubscriberConfig, connection *connectionManager, republishConnection *connectionManager, codecs *codec.Registry, logger logs.ILogger) (*messageSubscriber, error) {
 	subscriber := messageSubscriber{
-		config:  config,
-		channel: channel,
-		logger:  logger,
+		config:              config,
+		connection:          connection,
+		republishConnection: republishConnection,
+		logger:              logger,
+		metrics:             metrics.NoopMetrics{},
+		codecs:              codecs,
 	}
 
+	err := connection.addTopology(subscriber.declare)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscriber, nil
+}
+
+//close releases the resources the subscriber holds on to outside of its connections. It must be called once the subscriber has stopped consuming.
+func (subscriber *messageSubscriber) close() {
+	subscriber.decompressor.close()
+}
+
+//setMetrics replaces the metrics the subscriber reports to. It must be called before subscribe.
+func (subscriber *messageSubscriber) setMetrics(metrics metrics.IMetrics) {
+	subscriber.metrics = metrics
+}
+
+//setEncryptionKeys sets the key provider encrypted messages are decrypted with. It must be called before subscribe.
+func (subscriber *messageSubscriber) setEncryptionKeys(encryptionKeys encryption.IKeyProvider) {
+	subscriber.encryptionKeys = encryptionKeys
+}
+
+//setOrderingKeyProvider replaces how the ordering key of a message is chosen and enables ordered processing. It must be called before subscribe.
+func (subscriber *messageSubscriber) setOrderingKeyProvider(keyProvider processing.IOrderingKeyProvider) {
+	subscriber.keyProvider = keyProvider
+}
+
+func (subscriber *messageSubscriber) isOrdered() bool {
+	return subscriber.config.OrderedProcessing != nil || subscriber.keyProvider != nil
+}
+
+//setQueueName records the name of the declared queue. It is called from the reconnect goroutine whenever the topology is replayed.
+func (subscriber *messageSubscriber) setQueueName(queueName string) {
+	subscriber.mutex.Lock()
+	subscriber.queueName = queueName
+	subscriber.mutex.Unlock()
+}
+
+//currentQueueName returns the name of the queue as it was last declared.
+//		It differs from the configured name when RabbitMQ generates the name, in which case it can change after every reconnect.
+func (subscriber *messageSubscriber) currentQueueName() string {
+	subscriber.mutex.Lock()
+	defer subscriber.mutex.Unlock()
+	return subscriber.queueName
+}
+
+//declare declares the exchange and queue, sets the prefetch count, binds the queue to the exchange and declares any dead-letter and retry topology.
+//It is replayed after every reconnect as the prefetch count is scoped to the channel.
+func (subscriber *messageSubscriber) declare(channel *amqp.Channel) error {
+	config := subscriber.config
+
 	//Declare the exchange
 	err := channel.ExchangeDeclare(
 		config.ExchangeName,
@@ -36,7 +149,7 @@ func newMessageSubscriber(config models.SubscriberConfig, channel *amqp.Channel,
 		nil,
 	)
 	if err != nil {
-		subscriber.logger.LogError(err, "Error occurred while declaring exchange")
+		return &TopologyError{Entity: "exchange", Name: config.ExchangeName, Err: err}
 	}
 
 	//Declare the queue
@@ -46,19 +159,22 @@ func newMessageSubscriber(config models.SubscriberConfig, channel *amqp.Channel,
 		config.AutoDeleteQueue,
 		false,
 		false,
-		nil,
+		subscriber.deadLetterArguments(),
 	)
 	if err != nil {
-		subscriber.logger.LogError(err, "Error occurred while declaring queue")
+		return &TopologyError{Entity: "queue", Name: config.QueueName, Err: err}
 	}
-	subscriber.queue = q
+	subscriber.setQueueName(q.Name)
 
 	//Set the prefetch count
-	channel.Qos(
+	err = channel.Qos(
 		config.PrefetchCount,
 		0,
 		false,
 	)
+	if err != nil {
+		return &TopologyError{Entity: "prefetch count for queue", Name: config.QueueName, Err: err}
+	}
 
 	//Bind queue to exchange
 	err = channel.QueueBind(
@@ -69,52 +185,331 @@ func newMessageSubscriber(config models.SubscriberConfig, channel *amqp.Channel,
 		nil,
 	)
 	if err != nil {
-		subscriber.logger.LogError(err, "Error occured while binding queue to exchange")
+		return &TopologyError{Entity: "binding to exchange", Name: config.ExchangeName, Err: err}
+	}
+
+	if config.DeadLetterConfig != nil {
+		err = subscriber.declareDeadLetterTopology(channel, *config.DeadLetterConfig)
+		if err != nil {
+			return err
+		}
 	}
 
-	return &subscriber
+	if config.RetryConfig != nil {
+		return subscriber.declareRetryQueues(channel, *config.RetryConfig)
+	}
+
+	return nil
 }
 
-func (subscriber *messageSubscriber) subscribe(handler processing.IMessageHandler) error {
-	messages, err := subscriber.channel.Consume(
-		subscriber.queue.Name,
-		"", //TODO: Change this consumer name to something real - service name etc.
-		false,
-		false,
-		false,
-		false,
-		nil)
-	if err != nil {
-		subscriber.logger.LogError(err, fmt.Sprintf("Error occurred while attempting to setup consumer on channel againt queue %s", subscriber.config.QueueName))
+//subscribe consumes from the queue until the context is cancelled or the broker is closed.
+//		When the channel is lost, subscribe waits for the connection to be recovered and starts consuming from the new channel.
+//		Once the context is cancelled, the consumer is cancelled and subscribe waits for messages that are being handled to finish before returning.
+//		decodeData decodes the payloads of the messages handed to this handler only, so that concurrent subscriptions can decode payloads into different types.
+func (subscriber *messageSubscriber) subscribe(ctx context.Context, handler processing.IDispositionHandler, decodeData dataDecoder) error {
+	pool := newWorkerPool(
+		subscriber.config.WorkerCount(),
+		subscriber.isOrdered(),
+		subscriber.config.PrefetchCount,
+		subscriber.currentQueueName(),
+		func(message *consumedMessage) {
+			subscriber.handle(message, handler, decodeData)
+		},
+		subscriber.metrics)
+	stopWaking := context.AfterFunc(ctx, subscriber.connection.wake)
+	defer stopWaking()
+
+	var channel *amqp.Channel
+	var err error
+	for {
+		channel, err = subscriber.connection.awaitChannel(ctx, channel)
+		if err != nil {
+			break
+		}
+
+		consumerTag := newConsumerTag()
+		messages, consumeErr := channel.Consume(
+			subscriber.currentQueueName(),
+			consumerTag,
+			false,
+			false,
+			false,
+			false,
+			nil)
+		if consumeErr != nil {
+			subscriber.logger.LogInformation(fmt.Sprintf("Error occurred while attempting to setup consumer on channel againt queue %s. Waiting for the channel to be recovered\n\n%s",
+				subscriber.config.QueueName,
+				consumeErr))
+			continue
+		}
+
+		subscriber.consume(ctx, channel, consumerTag, messages, pool, decodeData)
+	}
+	if err == ErrBrokerClosed || err == ctx.Err() {
+		err = nil
 	}
 
-	wg := sync.WaitGroup{}
+	drainErr := subscriber.drain(pool)
+	if drainErr != nil {
+		return drainErr
+	}
+	return err
+}
 
-	for message := range messages {
-		wg.Add(1)
-		go func(message amqp.Delivery) {
-			defer wg.Done()
-			distributedMessage := models.DistributedMessage{}
-			err := json.Unmarshal(message.Body, &distributedMessage.Data)
-			if err != nil {
-				message.Nack(false, subscriber.config.RequeueOnNack)
-				subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while trying to parse message from RabbitMQ to DistributedMessage struct\n\n%s",
-					err))
-			}
-			distributedMessage.CorrelationId = message.CorrelationId
-			distributedMessage.MessageId = message.MessageId
-			distributedMessage.Timestamp = message.Timestamp
+//drain stops the worker pool and waits for the messages that are being handled to finish.
+//		ErrShutdownTimeout is returned if they have not finished within the subscriber's shutdown timeout.
+func (subscriber *messageSubscriber) drain(pool *workerPool) error {
+	select {
+	case <-pool.stop():
+		return nil
+	case <-time.After(subscriber.config.ShutdownTimeout()):
+		return ErrShutdownTimeout
+	}
+}
 
-			err = handler.HandleMessage(distributedMessage)
+//consume hands deliveries to the worker pool until the channel they are delivered on is closed or the context is cancelled.
+func (subscriber *messageSubscriber) consume(ctx context.Context, channel amqpConsumer, consumerTag string, messages <-chan amqp.Delivery, pool *workerPool, decodeData dataDecoder) {
+	for {
+		select {
+		case <-ctx.Done():
+			subscriber.cancel(channel, consumerTag, messages)
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			consumed := &consumedMessage{delivery: message}
+			key, err := subscriber.orderingKey(consumed, decodeData)
 			if err != nil {
-				message.Nack(false, subscriber.config.RequeueOnNack)
-				subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while handler was processing message\n\n%s",
-					err))
+				subscriber.settleUndecodable(message, err)
+				continue
+			}
+			if !pool.submit(ctx, consumed, key) {
+				message.Nack(false, true)
+				subscriber.cancel(channel, consumerTag, messages)
+				return
 			}
-			message.Ack(false) //Acknowledge just this message.
-		}(message)
+		}
 	}
-	wg.Wait()
+}
 
-	return nil
+//cancel stops RabbitMQ from delivering any more messages to the consumer.
+//		Messages that were already delivered but not yet handed to the handler are requeued straight away rather than waiting for the channel to close.
+func (subscriber *messageSubscriber) cancel(channel amqpConsumer, consumerTag string, messages <-chan amqp.Delivery) {
+	go func() {
+		err := channel.Cancel(consumerTag, false)
+		if err != nil {
+			subscriber.logger.LogInformation(fmt.Sprintf("Error occurred while cancelling consumer %s\n\n%s", consumerTag, err))
+		}
+	}()
+
+	for message := range messages {
+		message.Nack(false, true)
+	}
+}
+
+//orderingKey returns the key that decides which partition the message is handled on. It is empty if processing is not ordered.
+//		The key provider needs the decoded message, so the message is decoded here rather than by the worker that handles it.
+//		The decoding error is returned, without asking the key provider for a key, if the message cannot be decoded.
+func (subscriber *messageSubscriber) orderingKey(message *consumedMessage, decodeData dataDecoder) (string, error) {
+	switch {
+	case subscriber.keyProvider != nil:
+		distributedMessage, err := subscriber.decode(message, decodeData)
+		if err != nil {
+			return "", err
+		}
+		return subscriber.keyProvider.GetOrderingKey(distributedMessage), nil
+	case subscriber.config.OrderedProcessing == nil:
+		return "", nil
+	case subscriber.config.OrderedProcessing.KeyHeader != "":
+		key, ok := message.delivery.Headers[subscriber.config.OrderedProcessing.KeyHeader]
+		if !ok {
+			return "", nil
+		}
+		return fmt.Sprint(key), nil
+	default:
+		return message.delivery.CorrelationId, nil
+	}
+}
+
+//bodyDecoder decodes the payload of a consumed or returned message.
+type bodyDecoder struct {
+	codecs               *codec.Registry
+	encryptionKeys       encryption.IKeyProvider
+	decodeData           dataDecoder
+	decompressor         *decompressor
+	maxDecompressedBytes int
+}
+
+//decode decodes the payload of a consumed or returned message into the message.
+//		An encrypted body is decrypted first. An error wrapping encryption.ErrDecryptionFailed is returned if it cannot be.
+//		The body is then decompressed according to its content encoding. An error wrapping ErrUnknownContentEncoding is returned if it cannot be,
+//		and one wrapping ErrDecompressedBodyTooLarge if it decompresses to more than maxDecompressedBytes.
+//		A message published as a CloudEvent, in either content mode, has its data decoded instead, and its attributes mapped onto the message.
+//		The payload is decoded by the codec registered for its content type. An error wrapping codec.ErrUnknownContentType is returned if there is none.
+func (decoder bodyDecoder) decode(contentType string, contentEncoding string, headers amqp.Table, body []byte, distributedMessage *models.DistributedMessage) error {
+	body, err := decrypt(decoder.encryptionKeys, headers, body)
+	if err != nil {
+		return err
+	}
+
+	body, err = decoder.decompressor.decompress(contentEncoding, body, decoder.maxDecompressedBytes)
+	if err != nil {
+		return err
+	}
+
+	event, err := parseCloudEvent(contentType, headers, body)
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		event.applyTo(distributedMessage)
+		contentType, body = event.attributes.DataContentType, event.data
+	}
+
+	messageCodec, err := lookupCodec(decoder.codecs, contentType)
+	if err != nil {
+		return err
+	}
+	distributedMessage.Data, err = decoder.decodeData(messageCodec, body, headers)
+	return err
+}
+
+//decode converts the consumed message into a DistributedMessage. The message is only decoded the first time decode is called.
+//		The payload is decoded by a bodyDecoder, using decodeData.
+func (subscriber *messageSubscriber) decode(message *consumedMessage, decodeData dataDecoder) (models.DistributedMessage, error) {
+	message.decodeOnce.Do(func() {
+		delivery := message.delivery
+		distributedMessage := models.DistributedMessage{}
+		distributedMessage.CorrelationId = delivery.CorrelationId
+		distributedMessage.MessageId = delivery.MessageId
+		distributedMessage.Timestamp = delivery.Timestamp
+
+		decoder := bodyDecoder{
+			codecs:               subscriber.codecs,
+			encryptionKeys:       subscriber.encryptionKeys,
+			decodeData:           decodeData,
+			decompressor:         &subscriber.decompressor,
+			maxDecompressedBytes: subscriber.config.DecompressionLimit(),
+		}
+		err := decoder.decode(delivery.ContentType, delivery.ContentEncoding, delivery.Headers, delivery.Body, &distributedMessage)
+		distributedMessage.DeathHistory = deathHistory(delivery.Headers)
+		distributedMessage.Headers = messageHeaders(delivery.Headers)
+		distributedMessage.Delivery = deliveryInfo(delivery)
+
+		message.distributedMessage = distributedMessage
+		message.decodeErr = err
+	})
+	return message.distributedMessage, message.decodeErr
+}
+
+//deliveryInfo describes how the message was delivered and the properties it was published with.
+func deliveryInfo(delivery amqp.Delivery) *models.DeliveryInfo {
+	info := models.DeliveryInfo{
+		Exchange:        delivery.Exchange,
+		RoutingKey:      delivery.RoutingKey,
+		Redelivered:     delivery.Redelivered,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		ReplyTo:         delivery.ReplyTo,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		UserId:          delivery.UserId,
+		Priority:        delivery.Priority,
+		Expiration:      delivery.Expiration,
+		Persistent:      delivery.DeliveryMode == amqp.Persistent,
+		ConsumerTag:     delivery.ConsumerTag,
+	}
+	switch count := delivery.Headers[deliveryCountHeader].(type) {
+	case int64:
+		info.DeliveryCount = count
+	case int32:
+		info.DeliveryCount = int64(count)
+	case int16:
+		info.DeliveryCount = int64(count)
+	}
+	return &info
+}
+
+//handle passes the message to the handler and settles it according to the returned disposition.
+//		A message that cannot be decoded is never passed to the handler. It is dead-lettered instead, as handling it again would fail again.
+func (subscriber *messageSubscriber) handle(consumed *consumedMessage, handler processing.IDispositionHandler, decodeData dataDecoder) {
+	distributedMessage, err := subscriber.decode(consumed, decodeData)
+	if err != nil {
+		subscriber.settleUndecodable(consumed.delivery, err)
+		return
+	}
+
+	subscriber.settle(consumed.delivery, handler.HandleDelivery(distributedMessage))
+}
+
+//settleUndecodable settles a message that could not be decoded, without it ever reaching the handler.
+//		The message is dead-lettered if the subscriber has a dead-letter configuration, as rejecting it would otherwise discard it without a trace.
+//		Without one, it is moved to the parking queue if the subscriber has a retry configuration, and nacked according to RequeueOnNack otherwise.
+func (subscriber *messageSubscriber) settleUndecodable(message amqp.Delivery, err error) {
+	reason := fmt.Sprintf("Error occurred while trying to parse message from RabbitMQ to DistributedMessage struct: %s", err)
+	switch {
+	case subscriber.config.DeadLetterConfig != nil:
+		subscriber.settle(message, processing.DeadLetter(reason))
+	case subscriber.config.RetryConfig != nil:
+		err = subscriber.park(message, reason)
+		if err != nil {
+			subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while settling message with messageId=%s as park\n\n%s",
+				message.MessageId,
+				err))
+		}
+	default:
+		subscriber.settle(message, processing.Disposition{Action: processing.NackAction, Requeue: subscriber.config.RequeueOnNack, Reason: reason})
+	}
+}
+
+//settle carries out the disposition for the message.
+//		This is the only place a consumed message is acknowledged, nacked or rejected, which guarantees every message is settled exactly once.
+func (subscriber *messageSubscriber) settle(message amqp.Delivery, disposition processing.Disposition) {
+	var err error
+	switch disposition.Action {
+	case processing.AckAction:
+		err = message.Ack(false) //Acknowledge just this message.
+	case processing.NackAction:
+		if disposition.Reason != "" {
+			subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while handler was processing message\n\n%s",
+				disposition.Reason))
+		}
+		err = message.Nack(false, disposition.Requeue)
+	case processing.RejectAction:
+		err = message.Reject(false)
+	case processing.RetryAction:
+		err = subscriber.retry(message, disposition)
+	case processing.DeadLetterAction:
+		subscriber.logger.LogWarning(fmt.Sprintf("Message with messageId=%s was dead-lettered\n\n%s",
+			message.MessageId,
+			disposition.Reason))
+		err = message.Reject(false)
+	default:
+		//The handler cannot have meant to lose the message, so it is requeued.
+		subscriber.logger.LogWarning(fmt.Sprintf("Handler returned unknown disposition %s for message with messageId=%s. Requeueing the message",
+			disposition.Action,
+			message.MessageId))
+		err = message.Nack(false, true)
+	}
+
+	if err != nil {
+		subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while settling message with messageId=%s as %s\n\n%s",
+			message.MessageId,
+			disposition.Action,
+			err))
+	}
+}
+
+//newConsumerTag returns a consumer tag that identifies this process in the RabbitMQ management portal and is unique per consumer.
+func newConsumerTag() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%s-%d-%d",
+		filepath.Base(os.Args[0]),
+		hostname,
+		os.Getpid(),
+		atomic.AddUint64(&consumerSequence, 1))
 }
@@ -0,0 +1,419 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/metrics"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+//recordingAcknowledger records how a delivery was settled.
+type recordingAcknowledger struct {
+	acked    bool
+	nacked   bool
+	rejected bool
+	requeued bool
+}
+
+func (acknowledger *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	acknowledger.acked = true
+	return nil
+}
+
+func (acknowledger *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	acknowledger.nacked = true
+	acknowledger.requeued = requeue
+	return nil
+}
+
+func (acknowledger *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	acknowledger.rejected = true
+	acknowledger.requeued = requeue
+	return nil
+}
+
+//cancellingChannel stands in for the channel a consumer is cancelled on.
+//		Like RabbitMQ, it delivers the messages that were already on their way to the consumer and then closes the consumer's deliveries.
+type cancellingChannel struct {
+	messages  chan amqp.Delivery
+	inFlight  []amqp.Delivery
+	cancelled chan string
+}
+
+func (channel *cancellingChannel) Cancel(consumer string, noWait bool) error {
+	for _, message := range channel.inFlight {
+		channel.messages <- message
+	}
+	close(channel.messages)
+	channel.cancelled <- consumer
+	return nil
+}
+
+func TestDecode_GivenDelivery_ShouldExposeHeadersAndDeliveryMetadata(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{
+		Headers:       amqp.Table{"tenant": "acme", deliveryCountHeader: int64(2)},
+		ContentType:   "text/json",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      3,
+		CorrelationId: "correlation",
+		ReplyTo:       "replies",
+		MessageId:     "message",
+		Type:          "greeting",
+		AppId:         "orders-service",
+		ConsumerTag:   "consumer",
+		Redelivered:   true,
+		Exchange:      "orders",
+		RoutingKey:    "orders.created",
+		Body:          []byte(`"hello"`),
+	}}
+	expectedDelivery := &models.DeliveryInfo{
+		Exchange:      "orders",
+		RoutingKey:    "orders.created",
+		Redelivered:   true,
+		DeliveryCount: 2,
+		ContentType:   "text/json",
+		ReplyTo:       "replies",
+		Type:          "greeting",
+		AppId:         "orders-service",
+		Priority:      3,
+		Persistent:    true,
+		ConsumerTag:   "consumer",
+	}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", distributedMessage.Data)
+	assert.Equal(t, "acme", distributedMessage.Headers["tenant"])
+	assert.Equal(t, expectedDelivery, distributedMessage.Delivery)
+}
+
+func TestDecode_GivenDeliveryWithoutHeaders_ShouldLeaveHeadersNil(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{Body: []byte(`{}`)}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Nil(t, distributedMessage.Headers)
+	assert.False(t, distributedMessage.Delivery.Redelivered)
+}
+
+func TestDecode_GivenMessagePackDelivery_ShouldDecodeWithMessagePackCodec(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.MessagePack{}.Marshal(map[string]interface{}{"id": "1"})
+	message := &consumedMessage{delivery: amqp.Delivery{ContentType: "application/msgpack", Body: body}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "1"}, distributedMessage.Data)
+}
+
+func TestDecode_GivenUnknownContentType_ShouldReturnErrUnknownContentType(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{ContentType: "application/xml", Body: []byte(`<order/>`)}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.ErrorIs(t, err, codec.ErrUnknownContentType)
+}
+
+func TestDecode_GivenProtobufDeliveryWithTypeHeader_ShouldDecodeIntoRegisteredMessageType(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.Protobuf{}.Marshal(wrapperspb.String("hello"))
+	message := &consumedMessage{delivery: amqp.Delivery{
+		ContentType: "application/x-protobuf",
+		Headers:     amqp.Table{codec.TypeHeader: "google.protobuf.StringValue"},
+		Body:        body,
+	}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", distributedMessage.Data.(*wrapperspb.StringValue).GetValue())
+}
+
+func TestDecode_GivenProtobufDeliveryWithoutTypeHeader_ShouldReturnError(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.Protobuf{}.Marshal(wrapperspb.String("hello"))
+	message := &consumedMessage{delivery: amqp.Delivery{ContentType: "application/x-protobuf", Body: body}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestCurrentQueueName_GivenQueueRedeclaredWhileSubscribing_ShouldReturnLatestName(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{}
+	redeclared := make(chan struct{})
+
+	// Act
+	go func() {
+		subscriber.setQueueName("amq.gen-after-reconnect")
+		close(redeclared)
+	}()
+	subscriber.currentQueueName()
+	<-redeclared
+
+	// Assert
+	assert.Equal(t, "amq.gen-after-reconnect", subscriber.currentQueueName())
+}
+
+func TestSettle_GivenUnknownAction_ShouldNackAndRequeue(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{config: models.SubscriberConfig{RequeueOnNack: false}, logger: logs.Logger{}}
+
+	// Act
+	subscriber.settle(amqp.Delivery{Acknowledger: acknowledger}, processing.Disposition{Action: processing.Action(42)})
+
+	// Assert
+	assert.True(t, acknowledger.nacked)
+	assert.True(t, acknowledger.requeued)
+}
+
+func TestDecode_GivenPublishSequenceHeader_ShouldNotExposeIt(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{
+		Headers: amqp.Table{"tenant": "acme", publishSequenceHeader: int64(7)},
+		Body:    []byte(`{"id":"1"}`),
+	}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeAny)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"tenant": "acme"}, distributedMessage.Headers)
+}
+
+func TestConsume_GivenContextCancelled_ShouldCancelConsumerAndRequeueMessagesNotYetHandled(t *testing.T) {
+	// Arrange
+	handling, release := make(chan struct{}), make(chan struct{})
+	pool := newWorkerPool(1, false, 0, "test", func(message *consumedMessage) {
+		close(handling)
+		<-release
+		message.delivery.Ack(false)
+	}, metrics.NoopMetrics{})
+	handled, first, second := &recordingAcknowledger{}, &recordingAcknowledger{}, &recordingAcknowledger{}
+	channel := &cancellingChannel{
+		messages:  make(chan amqp.Delivery, 3),
+		inFlight:  []amqp.Delivery{{Acknowledger: first}, {Acknowledger: second}},
+		cancelled: make(chan string, 1),
+	}
+	channel.messages <- amqp.Delivery{Acknowledger: handled}
+	subscriber := messageSubscriber{logger: logs.Logger{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan struct{})
+	go func() {
+		subscriber.consume(ctx, channel, "consumer", channel.messages, pool, decodeAny)
+		close(consumed)
+	}()
+	<-handling
+
+	// Act
+	cancel()
+	<-consumed
+
+	// Assert
+	assert.Equal(t, "consumer", <-channel.cancelled)
+	for _, leftover := range []*recordingAcknowledger{first, second} {
+		assert.True(t, leftover.nacked)
+		assert.True(t, leftover.requeued)
+	}
+	close(release)
+	<-pool.stop()
+	assert.True(t, handled.acked)
+	assert.False(t, handled.nacked)
+}
+
+func TestDrain_GivenHandlerStillRunningAfterShutdownTimeout_ShouldReturnErrShutdownTimeout(t *testing.T) {
+	// Arrange
+	handling, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	pool := newWorkerPool(1, false, 0, "test", func(message *consumedMessage) {
+		close(handling)
+		<-release
+	}, metrics.NoopMetrics{})
+	pool.submit(context.Background(), &consumedMessage{}, "")
+	<-handling
+	subscriber := messageSubscriber{config: models.SubscriberConfig{ShutdownTimeoutMilliseconds: 20}}
+
+	// Act
+	started := time.Now()
+	err := subscriber.drain(pool)
+
+	// Assert
+	assert.Equal(t, ErrShutdownTimeout, err)
+	assert.GreaterOrEqual(t, time.Since(started), 20*time.Millisecond)
+}
+
+func TestDrain_GivenHandlersFinishInTime_ShouldReturnNil(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	pool := newWorkerPool(1, false, 0, "test", func(message *consumedMessage) {
+		time.Sleep(5 * time.Millisecond)
+		message.delivery.Ack(false)
+	}, metrics.NoopMetrics{})
+	pool.submit(context.Background(), &consumedMessage{delivery: amqp.Delivery{Acknowledger: acknowledger}}, "")
+	subscriber := messageSubscriber{config: models.SubscriberConfig{ShutdownTimeoutMilliseconds: 1000}}
+
+	// Act
+	err := subscriber.drain(pool)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, acknowledger.acked)
+}
+
+//countingKeyProvider counts the messages it is asked for an ordering key.
+type countingKeyProvider struct {
+	calls int
+}
+
+func (provider *countingKeyProvider) GetOrderingKey(distributedMessage models.DistributedMessage) string {
+	provider.calls++
+	return distributedMessage.CorrelationId
+}
+
+func TestConsume_GivenKeyProviderAndUndecodableMessage_ShouldDeadLetterWithoutAskingForKey(t *testing.T) {
+	// Arrange
+	keyProvider := &countingKeyProvider{}
+	handled := 0
+	pool := newWorkerPool(1, true, 1, "test", func(message *consumedMessage) {
+		handled++
+	}, metrics.NoopMetrics{})
+	acknowledger := &recordingAcknowledger{}
+	messages := make(chan amqp.Delivery, 1)
+	messages <- amqp.Delivery{Acknowledger: acknowledger, ContentType: "application/x-unknown", Body: []byte("order")}
+	close(messages)
+	subscriber := messageSubscriber{
+		config:      models.SubscriberConfig{DeadLetterConfig: &models.DeadLetterConfig{}},
+		codecs:      codec.NewDefaultRegistry(),
+		keyProvider: keyProvider,
+		logger:      logs.Logger{},
+	}
+
+	// Act
+	subscriber.consume(context.Background(), &cancellingChannel{}, "consumer", messages, pool, decodeAny)
+	<-pool.stop()
+
+	// Assert
+	assert.True(t, acknowledger.rejected)
+	assert.False(t, acknowledger.requeued)
+	assert.Equal(t, 0, keyProvider.calls)
+	assert.Equal(t, 0, handled)
+}
+
+func TestOrderingKey_GivenKeyProvider_ShouldDecodeMessageOnlyOnce(t *testing.T) {
+	// Arrange
+	decodes := 0
+	decodeData := func(messageCodec codec.ICodec, body []byte, headers amqp.Table) (interface{}, error) {
+		decodes++
+		return decodeAny(messageCodec, body, headers)
+	}
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry(), keyProvider: &countingKeyProvider{}}
+	message := &consumedMessage{delivery: amqp.Delivery{CorrelationId: "customer-1", Body: []byte(`"order"`)}}
+
+	// Act
+	key, err := subscriber.orderingKey(message, decodeData)
+	distributedMessage, decodeErr := subscriber.decode(message, decodeData)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, decodeErr)
+	assert.Equal(t, "customer-1", key)
+	assert.Equal(t, "order", distributedMessage.Data)
+	assert.Equal(t, 1, decodes)
+}
+
+func TestHandle_GivenUndecodableMessageAndDeadLetterConfig_ShouldDeadLetter(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{
+		config: models.SubscriberConfig{DeadLetterConfig: &models.DeadLetterConfig{}, RequeueOnNack: true},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	message := &consumedMessage{delivery: amqp.Delivery{Acknowledger: acknowledger, ContentType: "application/xml"}}
+
+	// Act
+	subscriber.handle(message, &recordingDispositionHandler{}, decodeAny)
+
+	// Assert
+	assert.True(t, acknowledger.rejected)
+	assert.False(t, acknowledger.requeued)
+}
+
+func TestHandle_GivenUndecodableMessageAndNoDeadLetterConfig_ShouldNackAccordingToRequeueOnNack(t *testing.T) {
+	for _, requeueOnNack := range []bool{true, false} {
+		// Arrange
+		acknowledger := &recordingAcknowledger{}
+		subscriber := messageSubscriber{
+			config: models.SubscriberConfig{RequeueOnNack: requeueOnNack},
+			codecs: codec.NewDefaultRegistry(),
+			logger: logs.Logger{},
+		}
+		message := &consumedMessage{delivery: amqp.Delivery{Acknowledger: acknowledger, ContentType: "application/xml"}}
+
+		// Act
+		subscriber.handle(message, &recordingDispositionHandler{}, decodeAny)
+
+		// Assert
+		assert.False(t, acknowledger.rejected)
+		assert.True(t, acknowledger.nacked)
+		assert.Equal(t, requeueOnNack, acknowledger.requeued)
+	}
+}
+
+func TestHandle_GivenUndecodableMessageAndOnlyRetryConfig_ShouldRequeueWhenItCannotBeParked(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{
+		config:              models.SubscriberConfig{QueueName: "orders", RetryConfig: &models.RetryConfig{}},
+		republishConnection: &connectionManager{closed: true},
+		codecs:              codec.NewDefaultRegistry(),
+		logger:              logs.Logger{},
+	}
+	message := &consumedMessage{delivery: amqp.Delivery{Acknowledger: acknowledger, ContentType: "application/xml"}}
+
+	// Act
+	subscriber.handle(message, &recordingDispositionHandler{}, decodeAny)
+
+	// Assert
+	assert.False(t, acknowledger.rejected)
+	assert.False(t, acknowledger.acked)
+	assert.True(t, acknowledger.nacked)
+	assert.True(t, acknowledger.requeued)
+}
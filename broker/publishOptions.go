@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+//PublishOption customises the AMQP properties of a single published message.
+//		Options are applied in order after the defaults, so a later option overrides an earlier one.
+//		Use the With... functions and Transient to create options. Any other AMQP property can be set by writing a PublishOption that changes the publishing directly.
+type PublishOption func(publishing *amqp.Publishing)
+
+//WithHeaders adds the given headers to the message. Headers with the same name as an existing header replace it.
+func WithHeaders(headers map[string]interface{}) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		if publishing.Headers == nil {
+			publishing.Headers = make(amqp.Table, len(headers))
+		}
+		for key, value := range headers {
+			publishing.Headers[key] = value
+		}
+	}
+}
+
+//WithHeader adds a single header to the message. A header with the same name replaces it.
+func WithHeader(key string, value interface{}) PublishOption {
+	return WithHeaders(map[string]interface{}{key: value})
+}
+
+//WithPriority sets the priority of the message, from 0 to 9.
+//		Priorities are only honoured by queues that were declared with the "x-max-priority" argument.
+func WithPriority(priority uint8) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.Priority = priority
+	}
+}
+
+//WithExpiration sets how long the message may wait in a queue before RabbitMQ discards (or dead-letters) it.
+//		RabbitMQ only supports whole milliseconds, so the expiration is rounded down to the millisecond. A negative expiration is ignored.
+func WithExpiration(expiration time.Duration) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		if expiration < 0 {
+			return
+		}
+		publishing.Expiration = strconv.FormatInt(int64(expiration/time.Millisecond), 10)
+	}
+}
+
+//WithType sets the application-specific type of the message, e.g. "order.created".
+func WithType(messageType string) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.Type = messageType
+	}
+}
+
+//WithAppId sets the identifier of the application that published the message.
+func WithAppId(appId string) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.AppId = appId
+	}
+}
+
+//WithUserId sets the user that published the message.
+//		RabbitMQ rejects the message unless this is the user the broker connected as.
+func WithUserId(userId string) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.UserId = userId
+	}
+}
+
+//WithReplyTo sets the queue that a reply to the message should be published to.
+func WithReplyTo(replyTo string) PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.ReplyTo = replyTo
+	}
+}
+
+//Transient publishes the message with transient delivery, so that RabbitMQ does not write it to disk.
+//		Transient messages are faster to publish but are lost if RabbitMQ restarts. Messages are persistent by default.
+func Transient() PublishOption {
+	return func(publishing *amqp.Publishing) {
+		publishing.DeliveryMode = amqp.Transient
+	}
+}
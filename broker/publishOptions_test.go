@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestNewPublishing_GivenPublishOptions_ShouldApplyOptionsOverDefaults(t *testing.T) {
+	// Arrange
+	publisher := messagePublisher{codecs: codec.NewDefaultRegistry(), logger: logs.Logger{}}
+	message := models.DistributedMessage{Data: "hello", MessageId: "1", CorrelationId: "2"}
+	expectedHeaders := amqp.Table{"tenant": "acme", "attempt": int32(1)}
+
+	// Act
+	publishing, err := publisher.newPublishing(message, []PublishOption{
+		WithHeaders(map[string]interface{}{"tenant": "acme"}),
+		WithHeader("attempt", int32(1)),
+		WithPriority(5),
+		WithExpiration(1500 * time.Millisecond),
+		WithType("greeting"),
+		WithAppId("orders-service"),
+		WithUserId("admin"),
+		WithReplyTo("replies"),
+		Transient(),
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHeaders, publishing.Headers)
+	assert.Equal(t, uint8(5), publishing.Priority)
+	assert.Equal(t, "1500", publishing.Expiration)
+	assert.Equal(t, "greeting", publishing.Type)
+	assert.Equal(t, "orders-service", publishing.AppId)
+	assert.Equal(t, "admin", publishing.UserId)
+	assert.Equal(t, "replies", publishing.ReplyTo)
+	assert.Equal(t, amqp.Transient, publishing.DeliveryMode)
+	assert.Equal(t, "1", publishing.MessageId)
+	assert.Equal(t, "2", publishing.CorrelationId)
+}
+
+func TestNewPublishing_GivenNoPublishOptions_ShouldPublishPersistentJSON(t *testing.T) {
+	// Arrange
+	publisher := messagePublisher{codecs: codec.NewDefaultRegistry(), logger: logs.Logger{}}
+	message := models.DistributedMessage{Data: "hello"}
+
+	// Act
+	publishing, err := publisher.newPublishing(message, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, amqp.Persistent, publishing.DeliveryMode)
+	assert.Equal(t, "application/json", publishing.ContentType)
+	assert.Empty(t, publishing.Expiration)
+	assert.Nil(t, publishing.Headers)
+}
+
+func TestNewPublishing_GivenContentType_ShouldEncodeWithCodecForContentType(t *testing.T) {
+	// Arrange
+	publisher := messagePublisher{
+		config: models.PublisherConfig{ContentType: "text/plain"},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	message := models.DistributedMessage{Data: "hello"}
+
+	// Act
+	publishing, err := publisher.newPublishing(message, nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", publishing.ContentType)
+	assert.Equal(t, []byte("hello"), publishing.Body)
+}
+
+func TestNewPublishing_GivenUnknownContentType_ShouldReturnErrUnknownContentType(t *testing.T) {
+	// Arrange
+	publisher := messagePublisher{
+		config: models.PublisherConfig{ContentType: "application/xml"},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	message := models.DistributedMessage{Data: "hello"}
+
+	// Act
+	_, err := publisher.newPublishing(message, nil)
+
+	// Assert
+	assert.ErrorIs(t, err, codec.ErrUnknownContentType)
+}
+
+func TestNewPublishing_GivenProtobufContentType_ShouldRecordMessageTypeInHeader(t *testing.T) {
+	// Arrange
+	publisher := messagePublisher{
+		config: models.PublisherConfig{ContentType: "application/x-protobuf"},
+		codecs: codec.NewDefaultRegistry(),
+		logger: logs.Logger{},
+	}
+	message := models.DistributedMessage{Data: wrapperspb.String("hello")}
+
+	// Act
+	publishing, err := publisher.newPublishing(message, []PublishOption{WithHeader("tenant", "acme")})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "application/x-protobuf", publishing.ContentType)
+	assert.Equal(t, amqp.Table{codec.TypeHeader: "google.protobuf.StringValue", "tenant": "acme"}, publishing.Headers)
+}
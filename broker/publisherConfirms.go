@@ -0,0 +1,301 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+const (
+	confirmationBufferSize = 128
+
+	//publishSequenceHeader carries the delivery tag of a mandatory message published in confirm mode so that a return can be matched to it.
+	//		AMQP has nowhere else to carry it, so it is delivered to every consumer. Subscribers built on this package strip it. Other consumers see it and should ignore it.
+	publishSequenceHeader = "x-publish-sequence"
+)
+
+//Confirmation describes whether RabbitMQ accepted a message that was published in confirm mode.
+//DeliveryTag is the sequence number the message was given on the channel it was published on.
+//Message is the message that was published.
+//Err is nil when RabbitMQ acknowledged the message.
+//		Err is ErrPublishNacked when RabbitMQ refused the message, or ErrConfirmationLost when the channel closed before RabbitMQ answered.
+//		Err is an *UnroutableError when the message was published as mandatory and RabbitMQ returned it.
+type Confirmation struct {
+	DeliveryTag uint64
+	Message     models.IDistributedMessage
+	Err         error
+}
+
+type pendingConfirmation struct {
+	message  models.IDistributedMessage
+	result   chan Confirmation
+	returned *amqp.Return
+}
+
+//amqpPublisher is the part of an *amqp.Channel that the confirm tracker publishes on.
+type amqpPublisher interface {
+	Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+}
+
+//returnCallback is called for every message RabbitMQ returns. message is nil if the return could not be matched to a pending message.
+type returnCallback func(returned amqp.Return, message models.IDistributedMessage)
+
+//confirmTracker correlates the delivery tags RabbitMQ confirms on a channel in confirm mode with the messages published on that channel.
+//A tracker belongs to a single channel. When the channel is replaced after a reconnect, a new tracker is created for the new channel.
+type confirmTracker struct {
+	channel      amqpPublisher
+	publishMutex sync.Mutex
+	nextTag      uint64
+	pendingMutex sync.Mutex
+	pending      map[uint64]*pendingConfirmation
+	closed       bool
+	returns      *returnQueue
+}
+
+//newConfirmTracker puts the channel into confirm mode and starts listening for confirmations.
+//		If returns is not nil, returned messages are matched to their pending confirmation and passed to onReturn on a goroutine of their own, so that a slow callback never holds up confirmations.
+func newConfirmTracker(channel *amqp.Channel, returns <-chan amqp.Return, onReturn returnCallback) (*confirmTracker, error) {
+	err := channel.Confirm(false)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmations := channel.NotifyPublish(make(chan amqp.Confirmation, confirmationBufferSize))
+	return startConfirmTracker(channel, confirmations, returns, onReturn), nil
+}
+
+//startConfirmTracker tracks the messages published on a channel that is already in confirm mode, resolving them from its confirmations and returns.
+func startConfirmTracker(channel amqpPublisher, confirmations <-chan amqp.Confirmation, returns <-chan amqp.Return, onReturn returnCallback) *confirmTracker {
+	tracker := confirmTracker{
+		channel: channel,
+		nextTag: 1,
+		pending: make(map[uint64]*pendingConfirmation),
+		returns: newReturnQueue(onReturn),
+	}
+	go tracker.listen(confirmations, returns)
+
+	return &tracker
+}
+
+//publish publishes the message on the tracked channel and returns a channel on which its confirmation will be delivered.
+//		Publishing is serialized so that delivery tags are handed out in the same order RabbitMQ assigns them.
+func (tracker *confirmTracker) publish(exchange string, routingKey string, mandatory bool, publishing amqp.Publishing, message models.IDistributedMessage) (<-chan Confirmation, error) {
+	tracker.publishMutex.Lock()
+	defer tracker.publishMutex.Unlock()
+
+	tag := tracker.nextTag
+	result := make(chan Confirmation, 1)
+
+	tracker.pendingMutex.Lock()
+	if tracker.closed {
+		tracker.pendingMutex.Unlock()
+		return nil, ErrNotConnected
+	}
+	tracker.pending[tag] = &pendingConfirmation{message: message, result: result}
+	tracker.pendingMutex.Unlock()
+
+	if mandatory {
+		publishing.Headers = copyTable(publishing.Headers)
+		publishing.Headers[publishSequenceHeader] = int64(tag)
+	}
+
+	err := tracker.channel.Publish(exchange, routingKey, mandatory, false, publishing)
+	if err != nil {
+		tracker.pendingMutex.Lock()
+		delete(tracker.pending, tag)
+		tracker.pendingMutex.Unlock()
+		return nil, err
+	}
+
+	tracker.nextTag++
+	return result, nil
+}
+
+//listen resolves pending messages as RabbitMQ confirms them.
+//		RabbitMQ sends the return for a mandatory message before its acknowledgement, so any waiting returns are processed before each confirmation.
+//		Once the channel closes, every message that is still pending is failed with ErrConfirmationLost.
+func (tracker *confirmTracker) listen(confirmations <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for confirmations != nil {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			tracker.markReturned(returned)
+		case confirmation, ok := <-confirmations:
+			if !ok {
+				confirmations = nil
+				continue
+			}
+			tracker.drainReturns(returns)
+			tracker.resolve(confirmation)
+		}
+	}
+
+	tracker.returns.close()
+
+	tracker.pendingMutex.Lock()
+	tracker.closed = true
+	pending := tracker.pending
+	tracker.pending = make(map[uint64]*pendingConfirmation)
+	tracker.pendingMutex.Unlock()
+
+	for tag, entry := range pending {
+		entry.result <- Confirmation{DeliveryTag: tag, Message: entry.message, Err: ErrConfirmationLost}
+	}
+}
+
+func (tracker *confirmTracker) drainReturns(returns <-chan amqp.Return) {
+	for {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				return
+			}
+			tracker.markReturned(returned)
+		default:
+			return
+		}
+	}
+}
+
+//markReturned records the return against the pending message it belongs to, so that its acknowledgement is reported as unroutable.
+func (tracker *confirmTracker) markReturned(returned amqp.Return) {
+	var message models.IDistributedMessage
+	tag, ok := returned.Headers[publishSequenceHeader].(int64)
+	if ok {
+		tracker.pendingMutex.Lock()
+		entry, found := tracker.pending[uint64(tag)]
+		if found {
+			entry.returned = &returned
+			message = entry.message
+		}
+		tracker.pendingMutex.Unlock()
+	}
+
+	tracker.returns.push(returned, message)
+}
+
+func (tracker *confirmTracker) resolve(confirmation amqp.Confirmation) {
+	tracker.pendingMutex.Lock()
+	entry, ok := tracker.pending[confirmation.DeliveryTag]
+	delete(tracker.pending, confirmation.DeliveryTag)
+	tracker.pendingMutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	var err error
+	switch {
+	case !confirmation.Ack:
+		err = ErrPublishNacked
+	case entry.returned != nil:
+		err = &UnroutableError{
+			ReplyCode:  entry.returned.ReplyCode,
+			ReplyText:  entry.returned.ReplyText,
+			Exchange:   entry.returned.Exchange,
+			RoutingKey: entry.returned.RoutingKey,
+		}
+	}
+	entry.result <- Confirmation{DeliveryTag: confirmation.DeliveryTag, Message: entry.message, Err: err}
+}
+
+//messageHeaders returns the headers of a consumed or returned message as they are exposed on the DistributedMessage.
+//		The header the broker uses to match returns to their confirmation is removed, as it was never set by the publisher. It returns nil if no headers are left.
+func messageHeaders(table amqp.Table) map[string]interface{} {
+	if _, ok := table[publishSequenceHeader]; ok {
+		table = copyTable(table)
+		delete(table, publishSequenceHeader)
+	}
+	if len(table) == 0 {
+		return nil
+	}
+	return map[string]interface{}(table)
+}
+
+//returnQueue passes returned messages to a return callback on a goroutine of its own, in the order they were returned.
+//		The queue is unbounded, so neither the goroutine that resolves confirmations nor the AMQP library's connection reader ever waits for the callback.
+//		That also lets the callback publish again, and wait for the confirmation, without deadlocking.
+type returnQueue struct {
+	onReturn returnCallback
+	mutex    sync.Mutex
+	queued   []queuedReturn
+	closed   bool
+	ready    chan struct{}
+}
+
+type queuedReturn struct {
+	returned amqp.Return
+	message  models.IDistributedMessage
+}
+
+//newReturnQueue starts passing queued returns to onReturn. Returns are dropped if onReturn is nil.
+func newReturnQueue(onReturn returnCallback) *returnQueue {
+	queue := returnQueue{
+		onReturn: onReturn,
+		ready:    make(chan struct{}, 1),
+	}
+	if onReturn != nil {
+		go queue.deliver()
+	}
+	return &queue
+}
+
+//push queues the returned message for the callback.
+func (queue *returnQueue) push(returned amqp.Return, message models.IDistributedMessage) {
+	if queue.onReturn == nil {
+		return
+	}
+
+	queue.mutex.Lock()
+	queue.queued = append(queue.queued, queuedReturn{returned: returned, message: message})
+	queue.mutex.Unlock()
+	queue.signal()
+}
+
+//close stops the queue once the returns that are already queued have been passed to the callback.
+func (queue *returnQueue) close() {
+	queue.mutex.Lock()
+	queue.closed = true
+	queue.mutex.Unlock()
+	queue.signal()
+}
+
+func (queue *returnQueue) signal() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *returnQueue) deliver() {
+	for range queue.ready {
+		for {
+			queue.mutex.Lock()
+			if len(queue.queued) == 0 {
+				closed := queue.closed
+				queue.mutex.Unlock()
+				if closed {
+					return
+				}
+				break
+			}
+			next := queue.queued[0]
+			queue.queued = queue.queued[1:]
+			queue.mutex.Unlock()
+
+			queue.onReturn(next.returned, next.message)
+		}
+	}
+}
+
+func copyTable(table amqp.Table) amqp.Table {
+	copied := make(amqp.Table, len(table)+1)
+	for key, value := range table {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,198 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/blockedPolicy"
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//recordingChannel stands in for an *amqp.Channel in confirm mode and records what is published on it.
+type recordingChannel struct {
+	mutex     sync.Mutex
+	published []amqp.Publishing
+}
+
+func (channel *recordingChannel) Publish(exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error {
+	channel.mutex.Lock()
+	channel.published = append(channel.published, msg)
+	channel.mutex.Unlock()
+	return nil
+}
+
+//newTestConfirmTracker returns a tracker whose confirmations and returns are delivered by the test rather than by RabbitMQ.
+func newTestConfirmTracker(onReturn returnCallback) (*confirmTracker, *recordingChannel, chan amqp.Confirmation, chan amqp.Return) {
+	channel := &recordingChannel{}
+	confirmations := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+	return startConfirmTracker(channel, confirmations, returns, onReturn), channel, confirmations, returns
+}
+
+//awaitConfirmation waits for the confirmation of a message, failing the test if it never arrives.
+func awaitConfirmation(t *testing.T, confirmation <-chan Confirmation) Confirmation {
+	select {
+	case result := <-confirmation:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was never confirmed")
+		return Confirmation{}
+	}
+}
+
+func TestConfirmTracker_GivenConfirmationsOutOfOrder_ShouldCorrelateByDeliveryTag(t *testing.T) {
+	// Arrange
+	tracker, _, confirmations, _ := newTestConfirmTracker(nil)
+	first := models.DistributedMessage{MessageId: "first"}
+	second := models.DistributedMessage{MessageId: "second"}
+	firstConfirmation, _ := tracker.publish("orders", "created", false, amqp.Publishing{}, first)
+	secondConfirmation, _ := tracker.publish("orders", "created", false, amqp.Publishing{}, second)
+
+	// Act
+	confirmations <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	confirmations <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+	// Assert
+	secondResult := awaitConfirmation(t, secondConfirmation)
+	firstResult := awaitConfirmation(t, firstConfirmation)
+	assert.Equal(t, uint64(1), firstResult.DeliveryTag)
+	assert.Equal(t, first, firstResult.Message)
+	assert.NoError(t, firstResult.Err)
+	assert.Equal(t, uint64(2), secondResult.DeliveryTag)
+	assert.Equal(t, second, secondResult.Message)
+	assert.NoError(t, secondResult.Err)
+}
+
+func TestConfirmTracker_GivenMultipleConfirm_ShouldConfirmEveryMessageUpToDeliveryTag(t *testing.T) {
+	// Arrange
+	tracker, _, confirmations, _ := newTestConfirmTracker(nil)
+	var pending []<-chan Confirmation
+	for i := 0; i < 3; i++ {
+		confirmation, _ := tracker.publish("orders", "created", false, amqp.Publishing{}, models.DistributedMessage{})
+		pending = append(pending, confirmation)
+	}
+
+	// Act
+	//The AMQP library splits an acknowledgement of multiple messages into a confirmation for each of them.
+	for tag := uint64(1); tag <= 3; tag++ {
+		confirmations <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+	}
+
+	// Assert
+	for i, confirmation := range pending {
+		result := awaitConfirmation(t, confirmation)
+		assert.Equal(t, uint64(i+1), result.DeliveryTag)
+		assert.NoError(t, result.Err)
+	}
+}
+
+func TestConfirmTracker_GivenNack_ShouldReturnErrPublishNacked(t *testing.T) {
+	// Arrange
+	tracker, _, confirmations, _ := newTestConfirmTracker(nil)
+	confirmation, _ := tracker.publish("orders", "created", false, amqp.Publishing{}, models.DistributedMessage{})
+
+	// Act
+	confirmations <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+
+	// Assert
+	assert.Equal(t, ErrPublishNacked, awaitConfirmation(t, confirmation).Err)
+}
+
+func TestConfirmTracker_GivenChannelClosedBeforeConfirm_ShouldReturnErrConfirmationLost(t *testing.T) {
+	// Arrange
+	tracker, _, confirmations, _ := newTestConfirmTracker(nil)
+	confirmation, _ := tracker.publish("orders", "created", false, amqp.Publishing{}, models.DistributedMessage{})
+
+	// Act
+	close(confirmations)
+
+	// Assert
+	assert.Equal(t, ErrConfirmationLost, awaitConfirmation(t, confirmation).Err)
+	assert.Eventually(t, func() bool {
+		_, err := tracker.publish("orders", "created", false, amqp.Publishing{}, models.DistributedMessage{})
+		return err == ErrNotConnected
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestConfirmTracker_GivenMandatoryMessageReturned_ShouldReturnUnroutableErrorAndPassMessageToCallback(t *testing.T) {
+	// Arrange
+	returnedMessages := make(chan models.IDistributedMessage, 1)
+	tracker, channel, confirmations, returns := newTestConfirmTracker(func(returned amqp.Return, message models.IDistributedMessage) {
+		returnedMessages <- message
+	})
+	message := models.DistributedMessage{MessageId: "unroutable"}
+	confirmation, _ := tracker.publish("orders", "created", true, amqp.Publishing{}, message)
+
+	// Act
+	returns <- amqp.Return{
+		ReplyCode:  amqp.NoRoute,
+		ReplyText:  "NO_ROUTE",
+		Exchange:   "orders",
+		RoutingKey: "created",
+		Headers:    channel.published[0].Headers,
+	}
+	confirmations <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+	// Assert
+	assert.Equal(t, int64(1), channel.published[0].Headers[publishSequenceHeader])
+	assert.Equal(t, &UnroutableError{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: "orders", RoutingKey: "created"}, awaitConfirmation(t, confirmation).Err)
+	assert.Equal(t, message, <-returnedMessages)
+}
+
+func TestConfirmTracker_GivenReturnCallbackStillRunning_ShouldStillResolveConfirmations(t *testing.T) {
+	// Arrange
+	release := make(chan struct{})
+	defer close(release)
+	tracker, channel, confirmations, returns := newTestConfirmTracker(func(returned amqp.Return, message models.IDistributedMessage) {
+		<-release
+	})
+	unroutable, _ := tracker.publish("orders", "created", true, amqp.Publishing{}, models.DistributedMessage{MessageId: "unroutable"})
+	routed, _ := tracker.publish("orders", "created", true, amqp.Publishing{}, models.DistributedMessage{MessageId: "routed"})
+
+	// Act
+	returns <- amqp.Return{ReplyCode: amqp.NoRoute, Headers: channel.published[0].Headers}
+	confirmations <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	confirmations <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+
+	// Assert
+	assert.IsType(t, &UnroutableError{}, awaitConfirmation(t, unroutable).Err)
+	assert.NoError(t, awaitConfirmation(t, routed).Err)
+}
+
+func TestPublish_GivenConfirmModeAndNoConfirmation_ShouldReturnErrConfirmTimeout(t *testing.T) {
+	// Arrange
+	tracker, _, _, _ := newTestConfirmTracker(nil)
+	connection := &amqp.Connection{}
+	publisher := &messagePublisher{
+		config: models.PublisherConfig{
+			ExchangeName:               "orders",
+			ConfirmMode:                true,
+			ConfirmTimeoutMilliseconds: 20,
+			BlockedPolicy:              blockedPolicy.FailFast,
+		},
+		connection: &connectionManager{},
+		codecs:     codec.NewDefaultRegistry(),
+		logger:     logs.Logger{},
+	}
+	publisher.pool = newChannelPool(
+		1,
+		func() (*amqp.Connection, error) {
+			return connection, nil
+		},
+		func(current *amqp.Connection) (*pooledChannel, error) {
+			return &pooledChannel{connection: current, tracker: tracker, closed: make(chan *amqp.Error)}, nil
+		})
+
+	// Act
+	err := publisher.publish(context.Background(), "created", models.DistributedMessage{Data: "order"}, nil)
+
+	// Assert
+	assert.Equal(t, ErrConfirmTimeout, err)
+}
@@ -0,0 +1,247 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
+	"github.com/streadway/amqp"
+)
+
+const (
+	//republishTimeout is how long a retried message waits for the republishing connection to be unblocked and for RabbitMQ to confirm its copy before it is requeued instead.
+	republishTimeout = 5 * time.Second
+
+	//retryAttemptHeader counts how many times a message has been retried through the retry queues.
+	retryAttemptHeader = "x-retry-attempt"
+
+	//retryReasonHeader records why a message was last retried.
+	retryReasonHeader = "x-retry-reason"
+)
+
+//declareRetryQueues declares a retry queue for every delay in the retry schedule, and the parking queue.
+//		Retry queues have no consumers. Their messages expire after the delay and are dead-lettered back to the subscriber's queue through the default exchange.
+func (subscriber *messageSubscriber) declareRetryQueues(channel *amqp.Channel, retryConfig models.RetryConfig) error {
+	queueName := subscriber.config.QueueName
+	for _, delay := range retryConfig.Delays() {
+		retryQueueName := retryConfig.RetryQueueName(queueName, delay)
+		_, err := channel.QueueDeclare(
+			retryQueueName,
+			subscriber.config.Durable,
+			false,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":             int64(delay / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queueName,
+			},
+		)
+		if err != nil {
+			return &TopologyError{Entity: "retry queue", Name: retryQueueName, Err: err}
+		}
+	}
+
+	parkingQueueName := retryConfig.ParkingQueue(queueName)
+	_, err := channel.QueueDeclare(
+		parkingQueueName,
+		subscriber.config.Durable,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return &TopologyError{Entity: "parking queue", Name: parkingQueueName, Err: err}
+	}
+
+	return nil
+}
+
+//retry arranges for the message to be handled again after a delay.
+//		Without a retry configuration there is nowhere to hold the message for the delay, so the message is nacked, and only requeued if the subscriber requeues on nack.
+//		Requeueing it unconditionally would redeliver it straight away, over and over, while the handler keeps failing.
+//		The worker is never blocked waiting for the delay, as that would hold up the messages behind it and delay shutdown.
+//		With a retry configuration, the message is republished to the retry queue for its attempt, or to the parking queue once it has run out of attempts, and then acknowledged.
+//		The message is only acknowledged once RabbitMQ has confirmed the copy. If republishing fails, or the copy is nacked or unroutable, the message is requeued so that it is not lost.
+func (subscriber *messageSubscriber) retry(message amqp.Delivery, disposition processing.Disposition) error {
+	if disposition.Reason != "" {
+		subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while handler was processing message. Retrying the message\n\n%s",
+			disposition.Reason))
+	}
+
+	if subscriber.config.RetryConfig == nil {
+		subscriber.logger.LogWarning(fmt.Sprintf("Message with messageId=%s cannot be retried after %s as the subscriber has no retry configuration. Nacking the message",
+			message.MessageId,
+			disposition.Delay))
+		return message.Nack(false, subscriber.config.RequeueOnNack)
+	}
+
+	retryConfig := *subscriber.config.RetryConfig
+	attempt := retryAttempt(message) + 1
+	routingKey := retryConfig.ParkingQueue(subscriber.config.QueueName)
+	if attempt <= retryConfig.AttemptLimit() {
+		routingKey = retryConfig.RetryQueueName(subscriber.config.QueueName, retryDelay(retryConfig, attempt, disposition.Delay))
+	}
+
+	err := subscriber.republish(message, routingKey, attempt, disposition.Reason)
+	if err != nil {
+		subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while moving message with messageId=%s to %s. Requeueing the message\n\n%s",
+			message.MessageId,
+			routingKey,
+			err))
+		return message.Nack(false, true)
+	}
+
+	return message.Ack(false)
+}
+
+//park moves the message straight to the parking queue, without spending any of its retry attempts, and acknowledges it once RabbitMQ has confirmed the copy.
+//		If republishing fails, the message is requeued so that it is not lost.
+func (subscriber *messageSubscriber) park(message amqp.Delivery, reason string) error {
+	parkingQueue := subscriber.config.RetryConfig.ParkingQueue(subscriber.config.QueueName)
+	err := subscriber.republish(message, parkingQueue, retryAttempt(message), reason)
+	if err != nil {
+		subscriber.logger.LogWarning(fmt.Sprintf("Error occurred while moving message with messageId=%s to %s. Requeueing the message\n\n%s",
+			message.MessageId,
+			parkingQueue,
+			err))
+		return message.Nack(false, true)
+	}
+
+	return message.Ack(false)
+}
+
+//republish publishes a copy of the message, with the given attempt recorded in its headers, straight to the named queue, and waits for RabbitMQ to confirm it.
+//		The copy is published as mandatory, so that a retry or parking queue that has been deleted is reported as an error rather than the message being dropped.
+func (subscriber *messageSubscriber) republish(message amqp.Delivery, queueName string, attempt int, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), republishTimeout)
+	defer cancel()
+
+	err := subscriber.awaitRepublishable(ctx)
+	if err != nil {
+		return err
+	}
+	republishing, err := subscriber.republishChannel()
+	if err != nil {
+		return err
+	}
+
+	headers := copyTable(message.Headers)
+	headers[retryAttemptHeader] = int64(attempt)
+	if reason != "" {
+		headers[retryReasonHeader] = reason
+	}
+
+	confirmation, err := republishing.tracker.publish(
+		"",
+		queueName,
+		true,
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     message.ContentType,
+			ContentEncoding: message.ContentEncoding,
+			DeliveryMode:    message.DeliveryMode,
+			Priority:        message.Priority,
+			CorrelationId:   message.CorrelationId,
+			ReplyTo:         message.ReplyTo,
+			MessageId:       message.MessageId,
+			Timestamp:       message.Timestamp,
+			Type:            message.Type,
+			UserId:          message.UserId,
+			AppId:           message.AppId,
+			Body:            message.Body,
+		},
+		nil)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case result := <-confirmation:
+		return result.Err
+	case <-ctx.Done():
+		return ErrConfirmTimeout
+	}
+}
+
+//awaitRepublishable waits for RabbitMQ to unblock the republishing connection, if it has blocked it because of a memory or disk alarm.
+//		ErrConnectionBlocked is returned if the connection is still blocked once the context is done, so that the message is requeued rather than holding up its worker any longer.
+func (subscriber *messageSubscriber) awaitRepublishable(ctx context.Context) error {
+	err := subscriber.republishConnection.awaitUnblocked(ctx)
+	if err != nil && err == ctx.Err() {
+		return ErrConnectionBlocked
+	}
+	return err
+}
+
+//republishChannel returns the channel retried messages are republished on, opening it on the republishing connection if it is not open yet.
+//		Retried messages are published on a connection of their own, so that RabbitMQ blocking it during an alarm cannot stall the acknowledgements on the consuming connection.
+//		The channel is in confirm mode, and is replaced once the connection has been.
+func (subscriber *messageSubscriber) republishChannel() (*pooledChannel, error) {
+	connection, err := subscriber.republishConnection.currentConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber.republishMutex.Lock()
+	defer subscriber.republishMutex.Unlock()
+
+	current := subscriber.republishing
+	if current != nil && current.connection == connection && !current.isClosed() {
+		return current, nil
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		return nil, &ConnectionError{Err: err}
+	}
+	republishing := pooledChannel{
+		channel:    channel,
+		connection: connection,
+		closed:     channel.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+	returns := channel.NotifyReturn(make(chan amqp.Return, returnBufferSize))
+	republishing.tracker, err = newConfirmTracker(channel, returns, nil)
+	if err != nil {
+		channel.Close()
+		return nil, &TopologyError{Entity: "confirm mode for retry queues of queue", Name: subscriber.config.QueueName, Err: err}
+	}
+
+	subscriber.republishing = &republishing
+	return &republishing, nil
+}
+
+//retryDelay returns the scheduled delay for the attempt.
+//		If the handler asked for a specific delay, the shortest scheduled delay that is at least as long is used instead, as only scheduled delays have a retry queue.
+func retryDelay(retryConfig models.RetryConfig, attempt int, requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return retryConfig.Delay(attempt)
+	}
+
+	delays := retryConfig.Delays()
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	for _, delay := range delays {
+		if delay >= requested {
+			return delay
+		}
+	}
+	return delays[len(delays)-1]
+}
+
+//retryAttempt returns how many times the message has already been retried.
+func retryAttempt(message amqp.Delivery) int {
+	switch attempt := message.Headers[retryAttemptHeader].(type) {
+	case int64:
+		return int(attempt)
+	case int32:
+		return int(attempt)
+	case int16:
+		return int(attempt)
+	default:
+		return 0
+	}
+}
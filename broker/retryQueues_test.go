@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/blockedPolicy"
+	"github.com/KrylixZA/GoRabbitMqBroker/logs"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
+	"github.com/streadway/amqp"
+)
+
+func TestRetryDelay_GivenNoRequestedDelay_ShouldFollowSchedule(t *testing.T) {
+	// Arrange
+	retryConfig := models.RetryConfig{BackoffMilliseconds: []int{100, 1000}}
+
+	// Act & Assert
+	assert.Equal(t, 100*time.Millisecond, retryDelay(retryConfig, 1, 0))
+	assert.Equal(t, time.Second, retryDelay(retryConfig, 2, 0))
+	assert.Equal(t, time.Second, retryDelay(retryConfig, 3, 0))
+}
+
+func TestRetryDelay_GivenRequestedDelay_ShouldUseShortestScheduledDelayThatIsLongEnough(t *testing.T) {
+	// Arrange
+	retryConfig := models.RetryConfig{BackoffMilliseconds: []int{5000, 100, 1000}}
+
+	// Act & Assert
+	assert.Equal(t, time.Second, retryDelay(retryConfig, 1, 500*time.Millisecond))
+	assert.Equal(t, 5*time.Second, retryDelay(retryConfig, 1, time.Minute))
+}
+
+func TestRetryAttempt_GivenAttemptHeader_ShouldReturnAttempt(t *testing.T) {
+	// Arrange
+	message := amqp.Delivery{Headers: amqp.Table{retryAttemptHeader: int32(2)}}
+
+	// Act
+	attempt := retryAttempt(message)
+
+	// Assert
+	assert.Equal(t, 2, attempt)
+	assert.Equal(t, 0, retryAttempt(amqp.Delivery{}))
+}
+
+func TestRetry_GivenNoRetryConfig_ShouldNackWithoutRequeueingOrWaitingForDelay(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{logger: logs.Logger{}}
+	started := time.Now()
+
+	// Act
+	err := subscriber.retry(amqp.Delivery{Acknowledger: acknowledger}, processing.Retry(time.Hour))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.True(t, acknowledger.nacked)
+	assert.False(t, acknowledger.requeued)
+	assert.Less(t, time.Since(started), time.Second)
+}
+
+func TestRetry_GivenNoRetryConfigAndRequeueOnNack_ShouldRequeue(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{config: models.SubscriberConfig{RequeueOnNack: true}, logger: logs.Logger{}}
+
+	// Act
+	err := subscriber.retry(amqp.Delivery{Acknowledger: acknowledger}, processing.Retry(time.Second))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.True(t, acknowledger.nacked)
+	assert.True(t, acknowledger.requeued)
+}
+
+func TestRetry_GivenRepublishFails_ShouldRequeueWithoutAcknowledging(t *testing.T) {
+	// Arrange
+	acknowledger := &recordingAcknowledger{}
+	subscriber := messageSubscriber{
+		config:              models.SubscriberConfig{QueueName: "orders", RetryConfig: &models.RetryConfig{}},
+		republishConnection: &connectionManager{closed: true},
+		logger:              logs.Logger{},
+	}
+
+	// Act
+	err := subscriber.retry(amqp.Delivery{Acknowledger: acknowledger}, processing.Retry(0))
+
+	// Assert
+	assert.Nil(t, err)
+	assert.False(t, acknowledger.acked)
+	assert.True(t, acknowledger.nacked)
+	assert.True(t, acknowledger.requeued)
+}
+
+func TestAwaitRepublishable_GivenConnectionStillBlockedAtDeadline_ShouldReturnErrConnectionBlocked(t *testing.T) {
+	// Arrange
+	publisher, blockings := newBlockedTestPublisher(blockedPolicy.Wait)
+	defer close(blockings)
+	subscriber := messageSubscriber{republishConnection: publisher.connection}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := subscriber.awaitRepublishable(ctx)
+
+	// Assert
+	assert.Equal(t, ErrConnectionBlocked, err)
+}
+
+func TestAwaitRepublishable_GivenConnectionUnblocked_ShouldReturnNil(t *testing.T) {
+	// Arrange
+	publisher, blockings := newBlockedTestPublisher(blockedPolicy.Wait)
+	defer close(blockings)
+	subscriber := messageSubscriber{republishConnection: publisher.connection}
+
+	// Act
+	blockings <- amqp.Blocking{Active: false}
+	err := subscriber.awaitRepublishable(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+}
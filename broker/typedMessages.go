@@ -0,0 +1,64 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/KrylixZA/GoRabbitMqBroker/processing"
+	"github.com/streadway/amqp"
+)
+
+//Subscribe is the generic counterpart of IMessageBroker.Subscribe for subscribers that consume payloads of a known type T.
+//		Every payload is decoded straight into T, so the handler receives a models.TypedMessage[T] rather than a map[string]interface{} it must convert itself.
+//		A message whose payload cannot be decoded into T is dead-lettered before the handler is called.
+//		For Protobuf payloads, T is a pointer to the generated message, e.g. *orders.OrderCreated. Messages that record a different Protobuf type are dead-lettered too.
+//		Go does not allow methods to have type parameters, which is why this is a function that takes the broker rather than a method on it.
+//		The broker must have been created by NewSubscriber or NewPublisherSubscriber, otherwise ErrUnsupportedBroker is returned.
+func Subscribe[T any](ctx context.Context, rmqBroker IMessageBroker, handler processing.ITypedMessageHandler[T]) error {
+	concreteBroker, ok := rmqBroker.(*messageBroker)
+	if !ok || concreteBroker == nil {
+		return ErrUnsupportedBroker
+	}
+	return concreteBroker.subscribe(ctx, processing.AdaptTypedMessageHandler[T](handler), decodeInto[T])
+}
+
+//SubscribeWithDisposition is the generic counterpart of IMessageBroker.SubscribeWithDisposition for subscribers that consume payloads of a known type T.
+//		Payloads are decoded in the same way as Subscribe[T].
+func SubscribeWithDisposition[T any](ctx context.Context, rmqBroker IMessageBroker, handler processing.ITypedDispositionHandler[T]) error {
+	concreteBroker, ok := rmqBroker.(*messageBroker)
+	if !ok || concreteBroker == nil {
+		return ErrUnsupportedBroker
+	}
+	return concreteBroker.subscribeWithDisposition(ctx, processing.AdaptTypedDispositionHandler[T](handler), decodeInto[T])
+}
+
+//Publish is the generic counterpart of IMessageBroker.PublishWithContext, which only accepts messages whose payload is a T.
+//		It lets the compiler check that a publisher and its subscribers agree on the type of the payload.
+func Publish[T any](ctx context.Context, rmqBroker IMessageBroker, routingKey string, typedMessage models.TypedMessage[T], options ...PublishOption) error {
+	return rmqBroker.PublishWithContext(ctx, routingKey, typedMessage, options...)
+}
+
+//decodeInto decodes the body of a consumed message into a T.
+//		It is only used by the subscription it is passed to, so other subscriptions on the same broker keep decoding payloads in their own way.
+//		Codecs that record the type of the payload, e.g. Protobuf, could otherwise decode a payload of one type into another that happens to share its wire format.
+//		An error wrapping ErrPayloadTypeMismatch is returned instead if the codec.TypeHeader header names a type other than T.
+func decodeInto[T any](messageCodec codec.ICodec, body []byte, headers amqp.Table) (interface{}, error) {
+	var data T
+	if typeRecordingCodec, ok := messageCodec.(codec.ITypeRecordingCodec); ok {
+		recorded, _ := headers[codec.TypeHeader].(string)
+		if recorded != "" {
+			expected, err := typeRecordingCodec.TypeName(data)
+			if err != nil {
+				return nil, err
+			}
+			if recorded != expected {
+				return nil, fmt.Errorf("%w: the message records %q but the subscription decodes into %q", ErrPayloadTypeMismatch, recorded, expected)
+			}
+		}
+	}
+
+	err := messageCodec.Unmarshal(body, &data)
+	return data, err
+}
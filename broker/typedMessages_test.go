@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/codec"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testOrder struct {
+	Id    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+type stubTypedMessageHandler struct{}
+
+func (handler stubTypedMessageHandler) HandleMessage(typedMessage models.TypedMessage[testOrder]) error {
+	return nil
+}
+
+func TestDecodeInto_GivenJSONDelivery_ShouldDecodePayloadIntoType(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{Body: []byte(`{"id":"1","total":10}`)}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeInto[testOrder])
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, testOrder{Id: "1", Total: 10}, distributedMessage.Data)
+}
+
+func TestDecodeInto_GivenCBORDelivery_ShouldDecodePayloadIntoType(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.CBOR{}.Marshal(testOrder{Id: "1", Total: 10})
+	message := &consumedMessage{delivery: amqp.Delivery{ContentType: "application/cbor", Body: body}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeInto[testOrder])
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, testOrder{Id: "1", Total: 10}, distributedMessage.Data)
+}
+
+func TestDecodeInto_GivenPayloadThatDoesNotFitType_ShouldReturnDecodeError(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	message := &consumedMessage{delivery: amqp.Delivery{Body: []byte(`{"id":1}`)}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeInto[testOrder])
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestDecodeInto_GivenProtobufDeliveryOfSubscribedType_ShouldDecodePayloadIntoType(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.Protobuf{}.Marshal(wrapperspb.String("hello"))
+	message := &consumedMessage{delivery: amqp.Delivery{
+		ContentType: "application/x-protobuf",
+		Headers:     amqp.Table{codec.TypeHeader: "google.protobuf.StringValue"},
+		Body:        body,
+	}}
+
+	// Act
+	distributedMessage, err := subscriber.decode(message, decodeInto[*wrapperspb.StringValue])
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", distributedMessage.Data.(*wrapperspb.StringValue).GetValue())
+}
+
+func TestDecodeInto_GivenProtobufDeliveryOfAnotherType_ShouldReturnErrPayloadTypeMismatch(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body, _ := codec.Protobuf{}.Marshal(wrapperspb.String("hello"))
+	message := &consumedMessage{delivery: amqp.Delivery{
+		ContentType: "application/x-protobuf",
+		Headers:     amqp.Table{codec.TypeHeader: "google.protobuf.StringValue"},
+		Body:        body,
+	}}
+
+	// Act
+	_, err := subscriber.decode(message, decodeInto[*wrapperspb.BytesValue])
+
+	// Assert
+	assert.ErrorIs(t, err, ErrPayloadTypeMismatch)
+}
+
+func TestDecodeInto_GivenUntypedSubscriptionOnSameSubscriber_ShouldNotChangeHowItDecodes(t *testing.T) {
+	// Arrange
+	subscriber := messageSubscriber{codecs: codec.NewDefaultRegistry()}
+	body := []byte(`{"id":"1","total":10}`)
+
+	// Act
+	typed, typedErr := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{Body: body}}, decodeInto[testOrder])
+	untyped, untypedErr := subscriber.decode(&consumedMessage{delivery: amqp.Delivery{Body: body}}, decodeAny)
+
+	// Assert
+	assert.NoError(t, typedErr)
+	assert.NoError(t, untypedErr)
+	assert.Equal(t, testOrder{Id: "1", Total: 10}, typed.Data)
+	assert.Equal(t, map[string]interface{}{"id": "1", "total": float64(10)}, untyped.Data)
+}
+
+func TestSubscribe_GivenPublisherOnlyBroker_ShouldReturnErrNotSubscriber(t *testing.T) {
+	// Arrange
+	rmqBroker := &messageBroker{}
+
+	// Act
+	err := Subscribe[testOrder](context.Background(), rmqBroker, stubTypedMessageHandler{})
+
+	// Assert
+	assert.Equal(t, ErrNotSubscriber, err)
+}
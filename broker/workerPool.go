@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/metrics"
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+	"github.com/streadway/amqp"
+)
+
+//consumedMessage is a message consumed from the queue on its way through the worker pool.
+//Its DistributedMessage is decoded at most once. That happens when choosing a partition if the ordering key depends on the payload, and otherwise when the message is handled.
+type consumedMessage struct {
+	delivery           amqp.Delivery
+	received           time.Time
+	decodeOnce         sync.Once
+	distributedMessage models.DistributedMessage
+	decodeErr          error
+}
+
+//workerPool hands consumed messages to a fixed number of workers so that no more than a set number of messages are handled at once.
+//		A message waits for a free worker before it is handled. The time it waits is reported to the metrics.
+//		When ordered, every worker has its own partition and messages are assigned to a partition by their key.
+//		Messages with the same key are then handled one at a time in the order they were consumed, while different partitions are handled in parallel.
+type workerPool struct {
+	partitions []chan *consumedMessage
+	next       uint32
+	workers    sync.WaitGroup
+	handle     func(message *consumedMessage)
+	metrics    metrics.IMetrics
+	queueName  string
+}
+
+//newWorkerPool starts size workers.
+//		If ordered is true, each worker reads from its own partition which buffers up to bufferSize messages.
+//		Otherwise all workers share a single unbuffered partition.
+func newWorkerPool(size int, ordered bool, bufferSize int, queueName string, handle func(message *consumedMessage), metrics metrics.IMetrics) *workerPool {
+	pool := workerPool{
+		handle:    handle,
+		metrics:   metrics,
+		queueName: queueName,
+	}
+
+	pool.workers.Add(size)
+	if ordered {
+		pool.partitions = make([]chan *consumedMessage, size)
+		for i := range pool.partitions {
+			pool.partitions[i] = make(chan *consumedMessage, bufferSize)
+			go pool.work(pool.partitions[i])
+		}
+	} else {
+		pool.partitions = []chan *consumedMessage{make(chan *consumedMessage)}
+		for i := 0; i < size; i++ {
+			go pool.work(pool.partitions[0])
+		}
+	}
+
+	return &pool
+}
+
+//submit blocks until the message's partition can accept it.
+//		Messages without a key are spread evenly across the partitions.
+//		It returns false, without handing the message to a worker, if the context is done first.
+func (pool *workerPool) submit(ctx context.Context, message *consumedMessage, key string) bool {
+	message.received = time.Now()
+
+	select {
+	case pool.partitions[pool.partitionFor(key)] <- message:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//stop tells the workers that no more messages will be submitted.
+//		The returned channel is closed once every worker has finished the messages already submitted to it.
+func (pool *workerPool) stop() <-chan struct{} {
+	for _, partition := range pool.partitions {
+		close(partition)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		pool.workers.Wait()
+		close(stopped)
+	}()
+	return stopped
+}
+
+func (pool *workerPool) partitionFor(key string) int {
+	if len(pool.partitions) == 1 {
+		return 0
+	}
+	if key == "" {
+		return int(atomic.AddUint32(&pool.next, 1) % uint32(len(pool.partitions)))
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(pool.partitions)))
+}
+
+func (pool *workerPool) work(partition <-chan *consumedMessage) {
+	defer pool.workers.Done()
+
+	for message := range partition {
+		pool.metrics.ObserveQueueWait(pool.queueName, time.Since(message.received))
+
+		started := time.Now()
+		pool.handle(message)
+		pool.metrics.ObserveHandlingDuration(pool.queueName, time.Since(started))
+	}
+}
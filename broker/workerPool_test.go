@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/metrics"
+	"github.com/streadway/amqp"
+)
+
+func TestWorkerPool_GivenOrderedPool_ShouldHandleMessagesWithTheSameKeyInOrder(t *testing.T) {
+	// Arrange
+	mutex := sync.Mutex{}
+	handled := map[string][]string{}
+	pool := newWorkerPool(4, true, 10, "test", func(message *consumedMessage) {
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		handled[message.delivery.CorrelationId] = append(handled[message.delivery.CorrelationId], message.delivery.MessageId)
+		mutex.Unlock()
+	}, metrics.NoopMetrics{})
+
+	// Act
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			message := &consumedMessage{delivery: amqp.Delivery{CorrelationId: key, MessageId: fmt.Sprint(i)}}
+			pool.submit(context.Background(), message, key)
+		}
+	}
+	<-pool.stop()
+
+	// Assert
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, handled[key])
+	}
+}
+
+func TestWorkerPool_GivenUnorderedPool_ShouldNotExceedWorkerCount(t *testing.T) {
+	// Arrange
+	mutex := sync.Mutex{}
+	running, maxRunning := 0, 0
+	pool := newWorkerPool(3, false, 0, "test", func(message *consumedMessage) {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	}, metrics.NoopMetrics{})
+
+	// Act
+	for i := 0; i < 20; i++ {
+		pool.submit(context.Background(), &consumedMessage{}, "")
+	}
+	<-pool.stop()
+
+	// Assert
+	assert.Equal(t, 3, maxRunning)
+}
+
+func TestWorkerPool_GivenCancelledContext_ShouldNotSubmitMessage(t *testing.T) {
+	// Arrange
+	block := make(chan struct{})
+	pool := newWorkerPool(1, false, 0, "test", func(message *consumedMessage) {
+		<-block
+	}, metrics.NoopMetrics{})
+	pool.submit(context.Background(), &consumedMessage{}, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	submitted := pool.submit(ctx, &consumedMessage{}, "")
+
+	// Assert
+	assert.False(t, submitted)
+	close(block)
+	<-pool.stop()
+}
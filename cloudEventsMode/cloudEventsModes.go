@@ -0,0 +1,25 @@
+//Package cloudEventsMode exposes an enumerable that represents how a publisher lays out the CloudEvents it publishes.
+//The purpose of this package is to simply the user experience of the user when setting up their configuration for connection to RabbitMQ.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package cloudEventsMode
+
+//CloudEventsMode defines which content mode of the CloudEvents AMQP protocol binding (https://github.com/cloudevents/spec/blob/main/cloudevents/bindings/amqp-protocol-binding.md) messages are published in.
+//		Subscribers understand both modes, regardless of the mode their own publisher uses.
+//Default cloudEventsMode is binary
+type CloudEventsMode int
+
+const (
+	//Binary keeps the payload as the body of the message, and carries the event attributes in headers prefixed with "cloudEvents_".
+	//		It is the most efficient mode, and leaves the body readable by subscribers that do not understand CloudEvents.
+	Binary CloudEventsMode = iota
+
+	//Structured wraps the payload and the event attributes in a JSON envelope, published with the "application/cloudevents+json" content type.
+	//		The envelope is self-contained, so it survives being forwarded through systems that drop message headers.
+	Structured
+)
+
+func (cloudEventsMode CloudEventsMode) String() string {
+	return [...]string{"binary", "structured"}[cloudEventsMode]
+}
@@ -0,0 +1,29 @@
+package codec
+
+import (
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+//cborDecoder decodes maps into a map[string]interface{}, rather than CBOR's default of map[interface{}]interface{}, so that decoded payloads look the same as JSON ones.
+var cborDecoder, _ = cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+
+//CBOR encodes payloads as CBOR (RFC 8949), a compact binary alternative to JSON.
+//		When decoded into a *interface{}, maps become a map[string]interface{}.
+type CBOR struct{}
+
+//ContentType returns "application/cbor".
+func (CBOR) ContentType() string {
+	return "application/cbor"
+}
+
+//Marshal encodes the payload as CBOR.
+func (CBOR) Marshal(payload interface{}) ([]byte, error) {
+	return cbor.Marshal(payload)
+}
+
+//Unmarshal decodes the CBOR body into target.
+func (CBOR) Unmarshal(body []byte, target interface{}) error {
+	return cborDecoder.Unmarshal(body, target)
+}
@@ -0,0 +1,100 @@
+//Package codec exposes an interface ICodec and a Registry of codecs, which decide how message payloads are encoded into and decoded from message bodies.
+//The purpose of this package is to let publishers choose how their payloads are encoded, and let subscribers decode every message according to its content type.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+//ErrUnknownContentType is returned when no codec is registered for the content type of a message.
+var ErrUnknownContentType = errors.New("no codec is registered for the content type")
+
+//TypeHeader is the header a message is published with when its codec records the type of its payload.
+const TypeHeader = "x-payload-type"
+
+//ICodec describes how a payload is encoded into the body of a message and decoded back out of it.
+//ContentType is the MIME type published with every message the codec encodes.
+//Marshal encodes the payload into a message body.
+//Unmarshal decodes a message body into target, which is a pointer.
+//		When target is a *interface{}, the codec chooses the Go type the payload is decoded into.
+type ICodec interface {
+	ContentType() string
+	Marshal(payload interface{}) ([]byte, error)
+	Unmarshal(body []byte, target interface{}) error
+}
+
+//ITypeRecordingCodec is implemented by codecs whose message bodies do not describe the type of the payload they hold, such as Protobuf.
+//		The type of the payload is recorded in the TypeHeader when the message is published, so that subscribers can decode it without knowing the type up front.
+//TypeName returns the name the type of the payload is recorded under.
+//NewPayload returns a new, empty payload of the named type, which is passed to Unmarshal as its target.
+type ITypeRecordingCodec interface {
+	ICodec
+	TypeName(payload interface{}) (string, error)
+	NewPayload(typeName string) (interface{}, error)
+}
+
+//Registry looks up the codec for a content type. It is safe for concurrent use.
+//		Content types are matched case-insensitively and without their parameters, so "application/json; charset=utf-8" matches "application/json".
+type Registry struct {
+	mutex  sync.RWMutex
+	codecs map[string]ICodec
+}
+
+//NewRegistry returns a registry holding the given codecs.
+func NewRegistry(codecs ...ICodec) *Registry {
+	registry := Registry{codecs: make(map[string]ICodec)}
+	for _, codec := range codecs {
+		registry.Register(codec)
+	}
+	return &registry
+}
+
+//NewDefaultRegistry returns a registry holding every codec in this package: JSON, Protobuf, MessagePack, CBOR, raw bytes and plain text.
+//		"text/json", which older versions of this library published JSON as, and the common "application/x-..." aliases are registered too.
+func NewDefaultRegistry() *Registry {
+	registry := NewRegistry(JSON{}, Protobuf{}, MessagePack{}, CBOR{}, Raw{}, Text{})
+	registry.RegisterAs("text/json", JSON{})
+	registry.RegisterAs("application/protobuf", Protobuf{})
+	registry.RegisterAs("application/x-msgpack", MessagePack{})
+	return registry
+}
+
+//Register registers the codec for its content type, replacing any codec already registered for it.
+func (registry *Registry) Register(codec ICodec) {
+	registry.RegisterAs(codec.ContentType(), codec)
+}
+
+//RegisterAs registers the codec for the given content type, which may differ from the codec's own, e.g. to accept an alias.
+func (registry *Registry) RegisterAs(contentType string, codec ICodec) {
+	registry.mutex.Lock()
+	registry.codecs[normalize(contentType)] = codec
+	registry.mutex.Unlock()
+}
+
+//Lookup returns the codec registered for the content type.
+//		An error wrapping ErrUnknownContentType is returned if there is none.
+func (registry *Registry) Lookup(contentType string) (ICodec, error) {
+	registry.mutex.RLock()
+	codec, ok := registry.codecs[normalize(contentType)]
+	registry.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+	}
+	return codec, nil
+}
+
+func normalize(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
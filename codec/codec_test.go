@@ -0,0 +1,173 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testOrder struct {
+	Id    string `json:"id" msgpack:"id"`
+	Total int    `json:"total" msgpack:"total"`
+}
+
+func TestLookup_GivenContentTypeWithParametersAndDifferentCase_ShouldReturnCodec(t *testing.T) {
+	// Arrange
+	registry := NewDefaultRegistry()
+
+	// Act
+	codec, err := registry.Lookup("Application/JSON; charset=utf-8")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, JSON{}, codec)
+}
+
+func TestLookup_GivenLegacyJSONContentType_ShouldReturnJSONCodec(t *testing.T) {
+	// Arrange
+	registry := NewDefaultRegistry()
+
+	// Act
+	codec, err := registry.Lookup("text/json")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, JSON{}, codec)
+}
+
+func TestLookup_GivenUnknownContentType_ShouldReturnErrUnknownContentType(t *testing.T) {
+	// Arrange
+	registry := NewDefaultRegistry()
+
+	// Act
+	_, err := registry.Lookup("application/xml")
+
+	// Assert
+	assert.ErrorIs(t, err, ErrUnknownContentType)
+}
+
+func TestRegister_GivenCodecForRegisteredContentType_ShouldReplaceCodec(t *testing.T) {
+	// Arrange
+	registry := NewRegistry(JSON{})
+
+	// Act
+	registry.RegisterAs("application/json", Text{})
+	codec, _ := registry.Lookup("application/json")
+
+	// Assert
+	assert.Equal(t, Text{}, codec)
+}
+
+func TestCodecs_GivenStruct_ShouldRoundTrip(t *testing.T) {
+	for _, codec := range []ICodec{JSON{}, MessagePack{}, CBOR{}} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			// Arrange
+			order := testOrder{Id: "1", Total: 10}
+
+			// Act
+			body, marshalErr := codec.Marshal(order)
+			var decoded testOrder
+			unmarshalErr := codec.Unmarshal(body, &decoded)
+
+			// Assert
+			assert.NoError(t, marshalErr)
+			assert.NoError(t, unmarshalErr)
+			assert.Equal(t, order, decoded)
+		})
+	}
+}
+
+func TestCodecs_GivenInterfaceTarget_ShouldDecodeObjectsAsStringKeyedMaps(t *testing.T) {
+	for _, codec := range []ICodec{JSON{}, MessagePack{}, CBOR{}} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			// Arrange
+			body, _ := codec.Marshal(map[string]interface{}{"id": "1"})
+
+			// Act
+			var decoded interface{}
+			err := codec.Unmarshal(body, &decoded)
+
+			// Assert
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"id": "1"}, decoded)
+		})
+	}
+}
+
+func TestProtobuf_GivenPointerToNilMessage_ShouldAllocateAndDecode(t *testing.T) {
+	// Arrange
+	body, _ := Protobuf{}.Marshal(wrapperspb.String("hello"))
+
+	// Act
+	var decoded *wrapperspb.StringValue
+	err := Protobuf{}.Unmarshal(body, &decoded)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", decoded.GetValue())
+}
+
+func TestProtobuf_GivenPayloadThatIsNotAMessage_ShouldReturnError(t *testing.T) {
+	// Act
+	_, err := Protobuf{}.Marshal("hello")
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestRaw_GivenBytes_ShouldRoundTrip(t *testing.T) {
+	// Act
+	body, marshalErr := Raw{}.Marshal([]byte{1, 2, 3})
+	var decoded interface{}
+	unmarshalErr := Raw{}.Unmarshal(body, &decoded)
+
+	// Assert
+	assert.NoError(t, marshalErr)
+	assert.NoError(t, unmarshalErr)
+	assert.Equal(t, []byte{1, 2, 3}, decoded)
+}
+
+func TestText_GivenString_ShouldRoundTrip(t *testing.T) {
+	// Act
+	body, marshalErr := Text{}.Marshal("hello")
+	var decoded string
+	unmarshalErr := Text{}.Unmarshal(body, &decoded)
+
+	// Assert
+	assert.NoError(t, marshalErr)
+	assert.NoError(t, unmarshalErr)
+	assert.Equal(t, "hello", decoded)
+}
+
+func TestProtobuf_GivenMessage_ShouldNameItsFullyQualifiedType(t *testing.T) {
+	// Act
+	typeName, err := Protobuf{}.TypeName(wrapperspb.String("hello"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "google.protobuf.StringValue", typeName)
+}
+
+func TestProtobuf_GivenRegisteredTypeName_ShouldDecodeIntoNewMessageOfThatType(t *testing.T) {
+	// Arrange
+	body, _ := Protobuf{}.Marshal(wrapperspb.String("hello"))
+
+	// Act
+	payload, newErr := Protobuf{}.NewPayload("google.protobuf.StringValue")
+	unmarshalErr := Protobuf{}.Unmarshal(body, payload)
+
+	// Assert
+	assert.NoError(t, newErr)
+	assert.NoError(t, unmarshalErr)
+	assert.Equal(t, "hello", payload.(*wrapperspb.StringValue).GetValue())
+}
+
+func TestProtobuf_GivenUnregisteredTypeName_ShouldReturnNotFound(t *testing.T) {
+	// Act
+	_, err := Protobuf{Types: new(protoregistry.Types)}.NewPayload("google.protobuf.StringValue")
+
+	// Assert
+	assert.ErrorIs(t, err, protoregistry.NotFound)
+}
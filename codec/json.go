@@ -0,0 +1,21 @@
+package codec
+
+import "encoding/json"
+
+//JSON encodes payloads as JSON. When decoded into a *interface{}, JSON objects become a map[string]interface{}.
+type JSON struct{}
+
+//ContentType returns "application/json".
+func (JSON) ContentType() string {
+	return "application/json"
+}
+
+//Marshal encodes the payload as JSON.
+func (JSON) Marshal(payload interface{}) ([]byte, error) {
+	return json.Marshal(payload)
+}
+
+//Unmarshal decodes the JSON body into target.
+func (JSON) Unmarshal(body []byte, target interface{}) error {
+	return json.Unmarshal(body, target)
+}
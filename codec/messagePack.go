@@ -0,0 +1,22 @@
+package codec
+
+import "github.com/vmihailenco/msgpack/v5"
+
+//MessagePack encodes payloads as MessagePack, a compact binary alternative to JSON.
+//		When decoded into a *interface{}, maps become a map[string]interface{}.
+type MessagePack struct{}
+
+//ContentType returns "application/msgpack".
+func (MessagePack) ContentType() string {
+	return "application/msgpack"
+}
+
+//Marshal encodes the payload as MessagePack.
+func (MessagePack) Marshal(payload interface{}) ([]byte, error) {
+	return msgpack.Marshal(payload)
+}
+
+//Unmarshal decodes the MessagePack body into target.
+func (MessagePack) Unmarshal(body []byte, target interface{}) error {
+	return msgpack.Unmarshal(body, target)
+}
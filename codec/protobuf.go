@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+//Protobuf encodes payloads that are Protocol Buffers messages in their binary wire format.
+//		The wire format does not describe which message it holds, so the fully-qualified name of the message, e.g. "orders.v1.OrderCreated", is recorded in the TypeHeader.
+//		Subscribers that do not know the type up front resolve it by that name from Types, and receive the decoded proto.Message as the payload.
+//Types is the registry message types are resolved from. If it is nil, the global registry every generated message registers itself with is used.
+//		A message type can only be resolved if the package that generated it is imported somewhere in the subscriber.
+type Protobuf struct {
+	Types *protoregistry.Types
+}
+
+//ContentType returns "application/x-protobuf".
+func (Protobuf) ContentType() string {
+	return "application/x-protobuf"
+}
+
+//Marshal encodes the payload, which must be a proto.Message.
+func (Protobuf) Marshal(payload interface{}) ([]byte, error) {
+	message, ok := payload.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec cannot encode a %T as it is not a proto.Message", payload)
+	}
+	return proto.Marshal(message)
+}
+
+//Unmarshal decodes the body into target, which must be a proto.Message, or a pointer to a proto.Message that is allocated if it is nil.
+func (Protobuf) Unmarshal(body []byte, target interface{}) error {
+	message, err := protoTarget(target)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(body, message)
+}
+
+//TypeName returns the fully-qualified name of the message, which must be a proto.Message.
+func (Protobuf) TypeName(payload interface{}) (string, error) {
+	message, ok := payload.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("protobuf codec cannot name a %T as it is not a proto.Message", payload)
+	}
+	return string(proto.MessageName(message)), nil
+}
+
+//NewPayload returns a new, empty message of the type with the fully-qualified name.
+//		An error wrapping protoregistry.NotFound is returned if the type is not registered.
+func (codec Protobuf) NewPayload(typeName string) (interface{}, error) {
+	if typeName == "" {
+		return nil, errors.New("protobuf codec cannot decode a message that does not record its type in the " + TypeHeader + " header")
+	}
+
+	types := codec.Types
+	if types == nil {
+		types = protoregistry.GlobalTypes
+	}
+	messageType, err := types.FindMessageByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec cannot resolve message type %q: %w", typeName, err)
+	}
+	return messageType.New().Interface(), nil
+}
+
+//protoTarget returns the proto.Message to decode into. A typed subscriber decodes into a pointer to its payload type, e.g. a **orders.Created, which is allocated here.
+func protoTarget(target interface{}) (proto.Message, error) {
+	if message, ok := target.(proto.Message); ok {
+		return message, nil
+	}
+	if message, ok := allocateProtoMessage(target); ok {
+		return message, nil
+	}
+	return nil, fmt.Errorf("protobuf codec cannot decode into a %T as it is not a proto.Message", target)
+}
+
+//allocateProtoMessage allocates the message that target, a pointer to a proto.Message, points at if it is nil.
+func allocateProtoMessage(target interface{}) (proto.Message, bool) {
+	pointer := reflect.ValueOf(target)
+	if pointer.Kind() != reflect.Ptr || pointer.IsNil() {
+		return nil, false
+	}
+	element := pointer.Elem()
+	if element.Kind() != reflect.Ptr || !element.Type().Implements(reflect.TypeOf((*proto.Message)(nil)).Elem()) {
+		return nil, false
+	}
+	if element.IsNil() {
+		element.Set(reflect.New(element.Type().Elem()))
+	}
+	return element.Interface().(proto.Message), true
+}
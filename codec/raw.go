@@ -0,0 +1,34 @@
+package codec
+
+import "fmt"
+
+//Raw passes payloads that are already encoded through untouched. Payloads must be a []byte, and are decoded as a []byte.
+type Raw struct{}
+
+//ContentType returns "application/octet-stream".
+func (Raw) ContentType() string {
+	return "application/octet-stream"
+}
+
+//Marshal returns the payload, which must be a []byte.
+func (Raw) Marshal(payload interface{}) ([]byte, error) {
+	body, ok := payload.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec cannot encode a %T as it is not a []byte", payload)
+	}
+	return body, nil
+}
+
+//Unmarshal copies the body into target, which must be a *[]byte or a *interface{}.
+func (Raw) Unmarshal(body []byte, target interface{}) error {
+	copied := append([]byte(nil), body...)
+	switch target := target.(type) {
+	case *[]byte:
+		*target = copied
+	case *interface{}:
+		*target = copied
+	default:
+		return fmt.Errorf("raw codec cannot decode into a %T. Use a *[]byte", target)
+	}
+	return nil
+}
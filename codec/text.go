@@ -0,0 +1,36 @@
+package codec
+
+import "fmt"
+
+//Text encodes payloads that are strings as UTF-8 plain text. Payloads must be a string or a []byte, and are decoded as a string.
+type Text struct{}
+
+//ContentType returns "text/plain".
+func (Text) ContentType() string {
+	return "text/plain"
+}
+
+//Marshal encodes the payload, which must be a string or a []byte.
+func (Text) Marshal(payload interface{}) ([]byte, error) {
+	switch payload := payload.(type) {
+	case string:
+		return []byte(payload), nil
+	case []byte:
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("text codec cannot encode a %T as it is not a string", payload)
+	}
+}
+
+//Unmarshal decodes the body into target, which must be a *string or a *interface{}.
+func (Text) Unmarshal(body []byte, target interface{}) error {
+	switch target := target.(type) {
+	case *string:
+		*target = string(body)
+	case *interface{}:
+		*target = string(body)
+	default:
+		return fmt.Errorf("text codec cannot decode into a %T. Use a *string", target)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//Package compressionAlgorithm exposes an enumerable that represents how a publisher compresses the bodies of large messages.
+//The purpose of this package is to simply the user experience of the user when setting up their configuration for connection to RabbitMQ.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package compressionAlgorithm
+
+//CompressionAlgorithm defines which algorithm compresses the body of a message. Its String() is the content encoding compressed messages are labelled with.
+//		Subscribers decompress every algorithm, regardless of the algorithm their own publisher uses.
+//Default compressionAlgorithm is gzip
+type CompressionAlgorithm int
+
+const (
+	//Gzip compresses with gzip (RFC 1952). It is understood by almost every language and tool, at the cost of speed.
+	Gzip CompressionAlgorithm = iota
+
+	//Zstd compresses with Zstandard (RFC 8878). It compresses better and faster than gzip.
+	Zstd
+
+	//Snappy compresses with the Snappy block format. It compresses least, but is the fastest.
+	Snappy
+)
+
+func (compressionAlgorithm CompressionAlgorithm) String() string {
+	return [...]string{"gzip", "zstd", "snappy"}[compressionAlgorithm]
+}
@@ -0,0 +1,146 @@
+//Package encryption exposes envelope encryption of message bodies with AES-GCM, and an interface IKeyProvider through which the keys are supplied.
+//The purpose of this package is to keep the bodies of messages that carry sensitive data encrypted while they sit in queues, and to allow the keys to be rotated without losing messages.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//KeyIdHeader is the header an encrypted message records the id of the key it was encrypted with in.
+//		A message with this header is decrypted before it is decoded. A message without it is treated as plain text.
+const KeyIdHeader = "x-encryption-key-id"
+
+const (
+	envelopeVersion byte = 1
+	dataKeySize          = 32
+)
+
+var (
+	//ErrDecryptionFailed is returned when a message cannot be decrypted, because its key is unknown, it was tampered with or it is not a valid envelope.
+	ErrDecryptionFailed = errors.New("the message could not be decrypted")
+
+	//ErrUnknownKey is returned by a key provider that does not hold the key with the given id.
+	ErrUnknownKey = errors.New("the key provider does not hold the key")
+)
+
+//IKeyProvider supplies the keys that encrypt the data keys of messages.
+//		Keys must be 16, 24 or 32 bytes long, for AES-128, AES-192 or AES-256.
+//CurrentKey returns the key new messages are encrypted with, and its id. The id is recorded in the KeyIdHeader of every message, so it must never be reused for a different key.
+//Key returns the key with the given id, so that messages encrypted before the current key was rotated in can still be decrypted.
+//		It returns an error wrapping ErrUnknownKey if the key is not held.
+//		Implementations can fetch keys from a key management service, and must be safe for concurrent use.
+type IKeyProvider interface {
+	CurrentKey() (keyId string, key []byte, err error)
+	Key(keyId string) ([]byte, error)
+}
+
+//Encrypt encrypts the plain text with envelope encryption, and returns the id of the key it was encrypted with alongside the envelope.
+//		Every message is encrypted with a new, random data key using AES-256-GCM. The data key is itself encrypted with the key provider's current key, and stored in the envelope.
+//		Rotating the current key therefore never requires messages to be re-encrypted. The old key just needs to stay available until the messages encrypted with it are consumed.
+func Encrypt(keyProvider IKeyProvider, plainText []byte) (string, []byte, error) {
+	keyId, key, err := keyProvider.CurrentKey()
+	if err != nil {
+		return "", nil, err
+	}
+	keyCipher, err := newCipher(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("key %s cannot be used for encryption: %w", keyId, err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	dataCipher, err := newCipher(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	//The key id is authenticated alongside the data key, so that changing the key id header makes decryption fail rather than use the wrong key.
+	wrappedKey, err := seal(keyCipher, dataKey, []byte(keyId))
+	if err != nil {
+		return "", nil, err
+	}
+	cipherText, err := seal(dataCipher, plainText, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	envelope := make([]byte, 0, 3+len(wrappedKey)+len(cipherText))
+	envelope = append(envelope, envelopeVersion)
+	envelope = binary.BigEndian.AppendUint16(envelope, uint16(len(wrappedKey)))
+	envelope = append(envelope, wrappedKey...)
+	envelope = append(envelope, cipherText...)
+	return keyId, envelope, nil
+}
+
+//Decrypt decrypts an envelope created by Encrypt, using the key with the given id from the key provider.
+//		An error wrapping ErrDecryptionFailed is returned if the envelope cannot be decrypted for any reason.
+func Decrypt(keyProvider IKeyProvider, keyId string, envelope []byte) ([]byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w: the body is not an encryption envelope", ErrDecryptionFailed)
+	}
+	wrappedKeyLength := int(binary.BigEndian.Uint16(envelope[1:3]))
+	if len(envelope) < 3+wrappedKeyLength {
+		return nil, fmt.Errorf("%w: the encryption envelope is truncated", ErrDecryptionFailed)
+	}
+	wrappedKey, cipherText := envelope[3:3+wrappedKeyLength], envelope[3+wrappedKeyLength:]
+
+	key, err := keyProvider.Key(keyId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	keyCipher, err := newCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %s cannot be used for decryption: %w", ErrDecryptionFailed, keyId, err)
+	}
+	dataKey, err := open(keyCipher, wrappedKey, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("%w: the data key cannot be decrypted with key %s", ErrDecryptionFailed, keyId)
+	}
+	dataCipher, err := newCipher(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	plainText, err := open(dataCipher, cipherText, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the body has been tampered with", ErrDecryptionFailed)
+	}
+	return plainText, nil
+}
+
+func newCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//seal encrypts the plain text with a random nonce, which is prepended to the cipher text.
+func seal(aead cipher.AEAD, plainText []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, additionalData), nil
+}
+
+//open decrypts cipher text created by seal.
+func open(aead cipher.AEAD, cipherText []byte, additionalData []byte) ([]byte, error) {
+	if len(cipherText) < aead.NonceSize() {
+		return nil, errors.New("cipher text is shorter than the nonce")
+	}
+	nonce, sealed := cipherText[:aead.NonceSize()], cipherText[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
@@ -0,0 +1,102 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyRing(t *testing.T) *KeyRing {
+	keyRing, err := NewKeyRing("2024-01", map[string][]byte{"2024-01": bytes.Repeat([]byte{1}, 32)})
+	assert.NoError(t, err)
+	return keyRing
+}
+
+func TestEncrypt_GivenPlainText_ShouldRoundTripWithCurrentKey(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t)
+
+	// Act
+	keyId, envelope, encryptErr := Encrypt(keyRing, []byte("hello"))
+	plainText, decryptErr := Decrypt(keyRing, keyId, envelope)
+
+	// Assert
+	assert.NoError(t, encryptErr)
+	assert.NoError(t, decryptErr)
+	assert.Equal(t, "2024-01", keyId)
+	assert.NotContains(t, string(envelope), "hello")
+	assert.Equal(t, []byte("hello"), plainText)
+}
+
+func TestDecrypt_GivenKeyWasRotated_ShouldDecryptWithOldKey(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t)
+	keyId, envelope, _ := Encrypt(keyRing, []byte("hello"))
+	assert.NoError(t, keyRing.Add("2024-02", bytes.Repeat([]byte{2}, 16)))
+	assert.NoError(t, keyRing.Rotate("2024-02"))
+
+	// Act
+	plainText, err := Decrypt(keyRing, keyId, envelope)
+	newKeyId, _, _ := Encrypt(keyRing, []byte("hello"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), plainText)
+	assert.Equal(t, "2024-02", newKeyId)
+}
+
+func TestDecrypt_GivenUnknownKeyId_ShouldReturnErrDecryptionFailed(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t)
+	_, envelope, _ := Encrypt(keyRing, []byte("hello"))
+
+	// Act
+	_, err := Decrypt(keyRing, "2023-12", envelope)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestDecrypt_GivenTamperedEnvelope_ShouldReturnErrDecryptionFailed(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t)
+	keyId, envelope, _ := Encrypt(keyRing, []byte("hello"))
+	envelope[len(envelope)-1] ^= 1
+
+	// Act
+	_, err := Decrypt(keyRing, keyId, envelope)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestDecrypt_GivenKeyIdOfAnotherKey_ShouldReturnErrDecryptionFailed(t *testing.T) {
+	// Arrange
+	keyRing := newTestKeyRing(t)
+	_, envelope, _ := Encrypt(keyRing, []byte("hello"))
+	assert.NoError(t, keyRing.Add("2024-02", bytes.Repeat([]byte{1}, 32)))
+
+	// Act
+	_, err := Decrypt(keyRing, "2024-02", envelope)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestNewKeyRing_GivenKeyOfInvalidLength_ShouldReturnError(t *testing.T) {
+	// Act
+	_, err := NewKeyRing("short", map[string][]byte{"short": []byte("too short")})
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestNewKeyRing_GivenCurrentKeyIdThatIsNotHeld_ShouldReturnErrUnknownKey(t *testing.T) {
+	// Act
+	_, err := NewKeyRing("missing", map[string][]byte{"2024-01": bytes.Repeat([]byte{1}, 32)})
+
+	// Assert
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
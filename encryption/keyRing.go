@@ -0,0 +1,82 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//KeyRing is an IKeyProvider that holds its keys in memory, e.g. keys loaded from configuration or a secret store at startup.
+//		It is safe for concurrent use.
+//To rotate keys, Add the new key, then Rotate to it once every subscriber holds it. Keep the old key until the messages encrypted with it have been consumed.
+type KeyRing struct {
+	mutex        sync.RWMutex
+	currentKeyId string
+	keys         map[string][]byte
+}
+
+//NewKeyRing returns a key ring holding the given keys, which encrypts new messages with the key with the current key id.
+//		An error is returned if the current key is not among the keys, or any key is not 16, 24 or 32 bytes long.
+func NewKeyRing(currentKeyId string, keys map[string][]byte) (*KeyRing, error) {
+	keyRing := KeyRing{keys: make(map[string][]byte, len(keys))}
+	for keyId, key := range keys {
+		err := keyRing.Add(keyId, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := keyRing.Rotate(currentKeyId)
+	if err != nil {
+		return nil, err
+	}
+	return &keyRing, nil
+}
+
+//Add adds a key to the ring, so that messages encrypted with it can be decrypted. It does not change the current key.
+func (keyRing *KeyRing) Add(keyId string, key []byte) error {
+	if keyId == "" {
+		return errors.New("key id is empty string")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("key %s is %d bytes long. Keys must be 16, 24 or 32 bytes long", keyId, len(key))
+	}
+
+	keyRing.mutex.Lock()
+	keyRing.keys[keyId] = append([]byte(nil), key...)
+	keyRing.mutex.Unlock()
+	return nil
+}
+
+//Rotate makes the key with the given id the key new messages are encrypted with. The key must already have been added.
+func (keyRing *KeyRing) Rotate(keyId string) error {
+	keyRing.mutex.Lock()
+	defer keyRing.mutex.Unlock()
+
+	if _, ok := keyRing.keys[keyId]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	keyRing.currentKeyId = keyId
+	return nil
+}
+
+//CurrentKey returns the key new messages are encrypted with, and its id.
+func (keyRing *KeyRing) CurrentKey() (string, []byte, error) {
+	keyRing.mutex.RLock()
+	defer keyRing.mutex.RUnlock()
+
+	return keyRing.currentKeyId, keyRing.keys[keyRing.currentKeyId], nil
+}
+
+//Key returns the key with the given id, or an error wrapping ErrUnknownKey if the ring does not hold it.
+func (keyRing *KeyRing) Key(keyId string) ([]byte, error) {
+	keyRing.mutex.RLock()
+	defer keyRing.mutex.RUnlock()
+
+	key, ok := keyRing.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyId)
+	}
+	return key, nil
+}
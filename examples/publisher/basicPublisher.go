@@ -31,7 +31,10 @@ func main() {
 		},
 	}
 
-	broker := broker.NewMessagePublisher(publisherConfig, logs.Logger{})
+	broker, err := broker.NewPublisher(publisherConfig, logs.Logger{})
+	if err != nil {
+		log.Fatalf("Failed to create publisher: %s", err)
+	}
 	defer broker.Close()
 
 	for i := 0; i < *numOfMsgsToPublish; i++ {
@@ -39,7 +42,10 @@ func main() {
 			Data: fmt.Sprintf("[%d] My test message", i),
 		}
 
-		broker.Publish("myTestRoutingKey", testDataPayload)
+		err = broker.Publish("myTestRoutingKey", testDataPayload)
+		if err != nil {
+			log.Printf("Failed to publish message %d: %s", i, err)
+		}
 	}
 }
 
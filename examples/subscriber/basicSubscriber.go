@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/KrylixZA/GoRabbitMqBroker/bindingType"
 	"github.com/KrylixZA/GoRabbitMqBroker/broker"
@@ -29,12 +33,22 @@ func main() {
 		},
 	}
 
-	broker := broker.NewMessageSubscriber(subscriberConfig, logs.Logger{})
+	broker, err := broker.NewSubscriber(subscriberConfig, logs.Logger{})
+	if err != nil {
+		log.Fatalf("Failed to create subscriber: %s", err)
+	}
 	defer broker.Close()
 
+	//Stop consuming and let messages being handled finish when the process is asked to shut down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	var subscriber processing.IMessageHandler
 	subscriber = basicSubscriber{}
-	broker.Subscribe(subscriber)
+	err = broker.Subscribe(ctx, subscriber)
+	if err != nil {
+		log.Printf("Subscription ended: %s", err)
+	}
 }
 
 type basicSubscriber struct {
@@ -0,0 +1,30 @@
+//Package hostSelection exposes an enumerable that represents the order in which the hosts of a RabbitMQ cluster are tried when connecting.
+//The purpose of this package is to simply the user experience of the user when setting up their configuration for connection to a RabbitMQ cluster.
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package hostSelection
+
+//HostSelection defines the order in which the broker tries the hosts of a cluster when it connects or reconnects.
+//		Whichever strategy is used, every host is tried once per attempt before the attempt is considered to have failed.
+//Default hostSelection is ordered
+type HostSelection int
+
+const (
+	//Ordered tries the hosts in the order they are configured, always starting with the first.
+	//		This suits a cluster with a preferred node and standby nodes.
+	Ordered HostSelection = iota
+
+	//RoundRobin starts with the host after the one that was last connected to, so that successive connections are spread across the cluster.
+	RoundRobin
+
+	//Random starts with a randomly chosen host and tries the rest in the order they are configured.
+	Random
+
+	//Shuffled tries the hosts in a random order that is shuffled again on every attempt.
+	Shuffled
+)
+
+func (hostSelection HostSelection) String() string {
+	return [...]string{"ordered", "roundRobin", "random", "shuffled"}[hostSelection]
+}
@@ -35,10 +35,10 @@ func (Logger) LogError(err error, message string) {
 	log.Panicf("ERROR %s", message)
 }
 
-//LogWarning writes a fatal message to the console.
-//		log.Fatalf will print out the error to the console but will not terminate execution.
+//LogWarning writes a warning message to the console.
+//		The warning is printed out to the console but will not terminate execution.
 func (Logger) LogWarning(message string) {
-	log.Fatal(message)
+	log.Printf("WARNING %s", message)
 }
 
 //LogInformation writes an information message to the console.
@@ -0,0 +1,32 @@
+//Package metrics exposes a simple interface through which the RabbitMQ broker library reports how long messages take to flow through a subscriber.
+//The implementation of the IMetrics interface is up the end user. A no-op implementation is used if none is provided.
+//If the user wishes to supply their own implementation, they must simply provide a type that implements the interface.
+//This flexibility allows the user to forward the measurements to whichever metrics system they already use (Prometheus, StatsD, etc).
+//Known issues can be found on GitHub (https://github.com/KrylixZA/GoRabbitMqBroker/issues).
+//This code is licensed under an MIT license.
+//Authors: Simon Headley (KrylixZA).
+package metrics
+
+import "time"
+
+//IMetrics provides a contract for recording how messages flow through a subscriber.
+//By using an interface, the underlying implementation can be changed and injected into the message broker.
+//		ObserveQueueWait records how long a consumed message waited for a free worker before its handler was called.
+//		ObserveHandlingDuration records how long the handler took to process a consumed message.
+//Both are called concurrently from the subscriber's workers, so implementations must be safe for concurrent use.
+type IMetrics interface {
+	ObserveQueueWait(queueName string, wait time.Duration)
+	ObserveHandlingDuration(queueName string, duration time.Duration)
+}
+
+//NoopMetrics is an implementation of IMetrics that discards every measurement.
+type NoopMetrics struct {
+}
+
+//ObserveQueueWait discards the measurement.
+func (NoopMetrics) ObserveQueueWait(queueName string, wait time.Duration) {
+}
+
+//ObserveHandlingDuration discards the measurement.
+func (NoopMetrics) ObserveHandlingDuration(queueName string, duration time.Duration) {
+}
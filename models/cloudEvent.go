@@ -0,0 +1,20 @@
+package models
+
+//CloudEvent holds the context attributes of a consumed message that was published as a CloudEvent (https://cloudevents.io).
+//		The id, time and correlationid attributes are not repeated here. They are mapped to the MessageId, Timestamp and CorrelationId of the message instead.
+//SpecVersion is the version of the CloudEvents specification the event conforms to, e.g. "1.0".
+//Source identifies the context in which the event happened, e.g. "/orders-service".
+//Type describes the kind of event, e.g. "com.example.order.created".
+//Subject identifies what the event is about within the source. It is optional.
+//DataSchema identifies the schema the data adheres to. It is optional.
+//DataContentType is the content type the data of the event is encoded as.
+//Extensions are any other attributes of the event.
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specVersion"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject,omitempty"`
+	DataSchema      string                 `json:"dataSchema,omitempty"`
+	DataContentType string                 `json:"dataContentType,omitempty"`
+	Extensions      map[string]interface{} `json:"extensions,omitempty"`
+}
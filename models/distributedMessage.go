@@ -32,11 +32,66 @@ type IDistributedMessage interface {
 //Data is of type interface{}, meaning it can contain anything as the data payload.
 //Timestamp is of time.Time. This significance of this time is only within the context of it's use.
 //CorrelationId is any string uniquely identifying the message to its source.
+//DeathHistory is the history of the times the message was dead-lettered, most recent first, as recorded by RabbitMQ in the "x-death" header.
+//		It is only populated on consumed messages, and is empty if the message has never been dead-lettered.
+//Headers are the AMQP headers the message was delivered with. It is only populated on consumed messages.
+//Delivery describes how the message was delivered, such as the exchange, routing key and whether it is a redelivery. It is only populated on consumed messages.
+//CloudEvent holds the CloudEvents attributes of a consumed message that was published as a CloudEvent. It is nil for other messages.
 type DistributedMessage struct {
-	Data          interface{} `json:"data"`
-	Timestamp     time.Time   `json:"timestamp"`
-	MessageId     string      `json:"messageId"`
-	CorrelationId string      `json:"correlationId"`
+	Data          interface{}            `json:"data"`
+	Timestamp     time.Time              `json:"timestamp"`
+	MessageId     string                 `json:"messageId"`
+	CorrelationId string                 `json:"correlationId"`
+	DeathHistory  []DeathRecord          `json:"deathHistory,omitempty"`
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Delivery      *DeliveryInfo          `json:"delivery,omitempty"`
+	CloudEvent    *CloudEvent            `json:"cloudEvent,omitempty"`
+}
+
+//DeliveryInfo describes how a consumed message was delivered, and the AMQP properties it was published with.
+//Exchange is the exchange the message was published to. It is empty if the message was published to the default exchange.
+//RoutingKey is the routing key the message was published with.
+//Redelivered is true if the message was delivered before, to this or another consumer, and was not acknowledged.
+//		A redelivered message may already have been processed, so handlers that are not idempotent should check it.
+//DeliveryCount is the number of times the message was delivered before, as counted by quorum queues in the "x-delivery-count" header. It is 0 for other queues.
+//ContentType & ContentEncoding describe how the body of the message is encoded.
+//ReplyTo is the queue a reply to the message should be published to.
+//Type, AppId & UserId are the application-specific type of the message, and the application and user that published it.
+//Priority is the priority the message was published with.
+//Expiration is how long, in milliseconds, the message could wait in a queue before it expired. It is empty if the message does not expire.
+//Persistent is true if the message was published with persistent delivery.
+//ConsumerTag is the tag of the consumer the message was delivered to.
+type DeliveryInfo struct {
+	Exchange        string `json:"exchange"`
+	RoutingKey      string `json:"routingKey"`
+	Redelivered     bool   `json:"redelivered"`
+	DeliveryCount   int64  `json:"deliveryCount"`
+	ContentType     string `json:"contentType"`
+	ContentEncoding string `json:"contentEncoding"`
+	ReplyTo         string `json:"replyTo"`
+	Type            string `json:"type"`
+	AppId           string `json:"appId"`
+	UserId          string `json:"userId"`
+	Priority        uint8  `json:"priority"`
+	Expiration      string `json:"expiration"`
+	Persistent      bool   `json:"persistent"`
+	ConsumerTag     string `json:"consumerTag"`
+}
+
+//DeathRecord describes why and where a message was dead-lettered, as recorded by RabbitMQ.
+//Queue is the queue the message was in when it was dead-lettered.
+//Reason is why the message was dead-lettered: "rejected", "expired", "maxlen" or "delivery_limit".
+//Count is how many times the message was dead-lettered from this queue for this reason.
+//Exchange is the exchange the message was published to before it was dead-lettered.
+//RoutingKeys are the routing keys the message was published with before it was dead-lettered.
+//Time is when the message was first dead-lettered from this queue for this reason.
+type DeathRecord struct {
+	Queue       string    `json:"queue"`
+	Reason      string    `json:"reason"`
+	Count       int64     `json:"count"`
+	Exchange    string    `json:"exchange"`
+	RoutingKeys []string  `json:"routingKeys"`
+	Time        time.Time `json:"time"`
 }
 
 //GetData is a raw implementation of the GetData() function defined in IDistributedMessage above.
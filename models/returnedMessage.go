@@ -0,0 +1,18 @@
+package models
+
+//ReturnedMessage describes a message that RabbitMQ returned to the publisher because it could not be routed to any queue.
+//		RabbitMQ only returns messages that were published while the publisher's MandatoryQueueBind is set to true.
+//ReplyCode is the AMQP reply code RabbitMQ gave for returning the message. Typically 312 (NO_ROUTE).
+//ReplyText is the human readable reason RabbitMQ gave for returning the message.
+//Exchange is the exchange the message was published to.
+//RoutingKey is the routing key the message was published with.
+//Message is the message that was published.
+//		In confirm mode this is the original IDistributedMessage passed to Publish.
+//		Otherwise it is a DistributedMessage rebuilt from the returned body and properties.
+type ReturnedMessage struct {
+	ReplyCode  uint16
+	ReplyText  string
+	Exchange   string
+	RoutingKey string
+	Message    IDistributedMessage
+}
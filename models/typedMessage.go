@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+//TypedMessage is the generic counterpart of DistributedMessage, whose payload is of a known type T instead of interface{}.
+//		It implements IDistributedMessage, so it can be published like any other message.
+//		When consumed through broker.Subscribe[T], the payload is decoded straight into T rather than into a map[string]interface{}.
+//Data is the payload of the message.
+//Timestamp, MessageId, CorrelationId, DeathHistory, Headers, Delivery & CloudEvent have the same meaning as on DistributedMessage.
+type TypedMessage[T any] struct {
+	Data          T                      `json:"data"`
+	Timestamp     time.Time              `json:"timestamp"`
+	MessageId     string                 `json:"messageId"`
+	CorrelationId string                 `json:"correlationId"`
+	DeathHistory  []DeathRecord          `json:"deathHistory,omitempty"`
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Delivery      *DeliveryInfo          `json:"delivery,omitempty"`
+	CloudEvent    *CloudEvent            `json:"cloudEvent,omitempty"`
+}
+
+//NewTypedMessage converts a DistributedMessage whose payload is a T into a TypedMessage[T].
+//		An error is returned if the payload is not a T.
+func NewTypedMessage[T any](distributedMessage DistributedMessage) (TypedMessage[T], error) {
+	data, ok := distributedMessage.Data.(T)
+	if !ok && distributedMessage.Data != nil {
+		return TypedMessage[T]{}, fmt.Errorf("the payload of message with messageId=%s is a %T, not a %T", distributedMessage.MessageId, distributedMessage.Data, data)
+	}
+
+	return TypedMessage[T]{
+		Data:          data,
+		Timestamp:     distributedMessage.Timestamp,
+		MessageId:     distributedMessage.MessageId,
+		CorrelationId: distributedMessage.CorrelationId,
+		DeathHistory:  distributedMessage.DeathHistory,
+		Headers:       distributedMessage.Headers,
+		Delivery:      distributedMessage.Delivery,
+		CloudEvent:    distributedMessage.CloudEvent,
+	}, nil
+}
+
+//GetData is a raw implementation of the GetData() function defined in IDistributedMessage.
+func (typedMessage TypedMessage[T]) GetData() interface{} {
+	return typedMessage.Data
+}
+
+//GetTimestamp is a raw implementation of the GetTimestamp() function defined in IDistributedMessage.
+func (typedMessage TypedMessage[T]) GetTimestamp() time.Time {
+	return typedMessage.Timestamp
+}
+
+//GetMessageId is a raw implementation of the GetMessageId() function defined in IDistributedMessage.
+func (typedMessage TypedMessage[T]) GetMessageId() string {
+	return typedMessage.MessageId
+}
+
+//GetCorrelationId is a raw implementation of the GetCorrelationId() function defined in IDistributedMessage.
+func (typedMessage TypedMessage[T]) GetCorrelationId() string {
+	return typedMessage.CorrelationId
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testOrder struct {
+	Id    string
+	Total int
+}
+
+func TestNewTypedMessage_GivenPayloadOfType_ShouldReturnTypedMessage(t *testing.T) {
+	// Arrange
+	distributedMessage := DistributedMessage{Data: testOrder{Id: "1", Total: 10}, MessageId: "message"}
+
+	// Act
+	typedMessage, err := NewTypedMessage[testOrder](distributedMessage)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, testOrder{Id: "1", Total: 10}, typedMessage.Data)
+	assert.Equal(t, "message", typedMessage.MessageId)
+}
+
+func TestNewTypedMessage_GivenPayloadOfOtherType_ShouldReturnError(t *testing.T) {
+	// Arrange
+	distributedMessage := DistributedMessage{Data: map[string]interface{}{"Id": "1"}, MessageId: "message"}
+
+	// Act
+	_, err := NewTypedMessage[testOrder](distributedMessage)
+
+	// Assert
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package processing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+)
+
+//Action defines what the subscriber does with a message once it has been handled.
+type Action int
+
+const (
+	//AckAction acknowledges the message, removing it from the queue.
+	AckAction Action = iota
+
+	//NackAction negatively acknowledges the message. The message is either requeued or discarded (or dead-lettered, if the queue has a dead-letter exchange).
+	NackAction
+
+	//RejectAction rejects the message without requeueing it. The message is discarded (or dead-lettered, if the queue has a dead-letter exchange).
+	RejectAction
+
+	//RetryAction asks for the message to be handled again after a delay. The subscriber needs a retry configuration to honour the delay, and nacks the message otherwise.
+	RetryAction
+
+	//DeadLetterAction rejects the message so that it is routed to the queue's dead-letter exchange, if it has one, and records why.
+	DeadLetterAction
+)
+
+func (action Action) String() string {
+	names := [...]string{"ack", "nack", "reject", "retry", "deadLetter"}
+	if action < 0 || int(action) >= len(names) {
+		return fmt.Sprintf("unknown(%d)", int(action))
+	}
+	return names[action]
+}
+
+//Disposition describes what the subscriber must do with a message once the handler has finished with it.
+//The subscriber honours the disposition exactly once per message.
+//Use the Ack, Nack, Reject, Retry and DeadLetter functions to create a Disposition. Actions the subscriber does not know are nacked and requeued.
+//Action is what the subscriber does with the message.
+//Requeue defines whether a nacked message is put back on the queue.
+//Delay is how long to wait before a retried message is handled again.
+//Reason explains why a message was not acknowledged. The subscriber logs it when the message is nacked, retried or dead-lettered.
+type Disposition struct {
+	Action  Action
+	Requeue bool
+	Delay   time.Duration
+	Reason  string
+}
+
+//Ack returns a Disposition that acknowledges the message.
+func Ack() Disposition {
+	return Disposition{Action: AckAction}
+}
+
+//Nack returns a Disposition that negatively acknowledges the message and, if requeue is true, puts it back on the queue.
+func Nack(requeue bool) Disposition {
+	return Disposition{Action: NackAction, Requeue: requeue}
+}
+
+//Reject returns a Disposition that rejects the message without requeueing it.
+func Reject() Disposition {
+	return Disposition{Action: RejectAction}
+}
+
+//Retry returns a Disposition that asks for the message to be handled again once the delay has passed.
+//		If the subscriber has a retry configuration and the delay is zero, the delay is taken from the retry schedule instead.
+//		Without a retry configuration the delay cannot be honoured. The message is nacked instead, and only requeued if the subscriber's RequeueOnNack is set.
+func Retry(after time.Duration) Disposition {
+	return Disposition{Action: RetryAction, Delay: after}
+}
+
+//DeadLetter returns a Disposition that routes the message to the queue's dead-letter exchange and records the reason.
+func DeadLetter(reason string) Disposition {
+	return Disposition{Action: DeadLetterAction, Reason: reason}
+}
+
+//IDispositionHandler describes a contract for subscribers that want to decide exactly what happens to every message they handle.
+//The disposition handler will be called when a message is ready to be consumed.
+//The disposition handler will be called in an asynchronous manner.
+type IDispositionHandler interface {
+	HandleDelivery(distributedMessage models.DistributedMessage) Disposition
+}
+
+//AdaptMessageHandler turns an IMessageHandler into an IDispositionHandler.
+//		A nil error acknowledges the message. Any other error nacks the message and requeues it if requeueOnNack is true.
+func AdaptMessageHandler(handler IMessageHandler, requeueOnNack bool) IDispositionHandler {
+	return messageHandlerAdapter{
+		handler:       handler,
+		requeueOnNack: requeueOnNack,
+	}
+}
+
+//AdaptMessageHandlerWithRetry turns an IMessageHandler into an IDispositionHandler that retries failed messages.
+//		A nil error acknowledges the message. Any other error retries the message after the delay the subscriber's retry schedule gives for the attempt.
+func AdaptMessageHandlerWithRetry(handler IMessageHandler) IDispositionHandler {
+	return messageHandlerAdapter{
+		handler: handler,
+		retry:   true,
+	}
+}
+
+type messageHandlerAdapter struct {
+	handler       IMessageHandler
+	requeueOnNack bool
+	retry         bool
+}
+
+func (adapter messageHandlerAdapter) HandleDelivery(distributedMessage models.DistributedMessage) Disposition {
+	err := adapter.handler.HandleMessage(distributedMessage)
+	if err == nil {
+		return Ack()
+	}
+	if adapter.retry {
+		return Disposition{Action: RetryAction, Reason: err.Error()}
+	}
+	return Disposition{Action: NackAction, Requeue: adapter.requeueOnNack, Reason: err.Error()}
+}
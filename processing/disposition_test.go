@@ -0,0 +1,65 @@
+package processing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+)
+
+type stubMessageHandler struct {
+	err error
+}
+
+func (handler stubMessageHandler) HandleMessage(distributedMessage models.DistributedMessage) error {
+	return handler.err
+}
+
+func TestAdaptMessageHandler_GivenHandlerReturnsNil_ShouldAck(t *testing.T) {
+	// Arrange
+	handler := AdaptMessageHandler(stubMessageHandler{}, true)
+
+	// Act
+	disposition := handler.HandleDelivery(models.DistributedMessage{})
+
+	// Assert
+	assert.Equal(t, Ack(), disposition)
+}
+
+func TestAdaptMessageHandler_GivenHandlerReturnsError_ShouldNackWithConfiguredRequeue(t *testing.T) {
+	// Arrange
+	handler := AdaptMessageHandler(stubMessageHandler{err: errors.New("test")}, true)
+
+	// Act
+	disposition := handler.HandleDelivery(models.DistributedMessage{})
+
+	// Assert
+	assert.Equal(t, NackAction, disposition.Action)
+	assert.True(t, disposition.Requeue)
+	assert.Equal(t, "test", disposition.Reason)
+}
+
+func TestAdaptMessageHandlerWithRetry_GivenHandlerReturnsError_ShouldRetry(t *testing.T) {
+	// Arrange
+	handler := AdaptMessageHandlerWithRetry(stubMessageHandler{err: errors.New("test")})
+
+	// Act
+	disposition := handler.HandleDelivery(models.DistributedMessage{})
+
+	// Assert
+	assert.Equal(t, RetryAction, disposition.Action)
+	assert.Equal(t, "test", disposition.Reason)
+}
+
+func TestActionString_GivenUnknownAction_ShouldNotPanic(t *testing.T) {
+	// Arrange
+	action := Action(42)
+
+	// Act
+	name := action.String()
+
+	// Assert
+	assert.Equal(t, "unknown(42)", name)
+}
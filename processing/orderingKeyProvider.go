@@ -0,0 +1,12 @@
+package processing
+
+import "github.com/KrylixZA/GoRabbitMqBroker/models"
+
+//IOrderingKeyProvider describes a contract for subscribers that want to choose which messages must be handled in order.
+//Messages for which GetOrderingKey returns the same key are handled one at a time, in the order they were consumed.
+//Messages with different keys may be handled in parallel. An empty key means the message has no ordering requirement.
+//GetOrderingKey is called from a single goroutine for every consumed message, so it should be cheap.
+//It is not called for messages that cannot be decoded. Those are settled straight away, without being handled.
+type IOrderingKeyProvider interface {
+	GetOrderingKey(distributedMessage models.DistributedMessage) string
+}
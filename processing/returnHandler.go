@@ -0,0 +1,10 @@
+package processing
+
+import "github.com/KrylixZA/GoRabbitMqBroker/models"
+
+//IReturnHandler describes a contract for publishers that want to be told when RabbitMQ returns a message it could not route.
+//The return handler will only be called for messages published while the publisher's MandatoryQueueBind is set to true.
+//The return handler is called from the goroutine that listens for returns, so it should not block for long.
+type IReturnHandler interface {
+	HandleReturn(returnedMessage models.ReturnedMessage)
+}
@@ -0,0 +1,51 @@
+package processing
+
+import "github.com/KrylixZA/GoRabbitMqBroker/models"
+
+//ITypedMessageHandler is the generic counterpart of IMessageHandler for subscribers that consume payloads of a known type T.
+//		Use it with broker.Subscribe[T], which decodes every payload straight into T before the handler is called.
+type ITypedMessageHandler[T any] interface {
+	HandleMessage(typedMessage models.TypedMessage[T]) error
+}
+
+//ITypedDispositionHandler is the generic counterpart of IDispositionHandler for subscribers that consume payloads of a known type T.
+//		Use it with broker.SubscribeWithDisposition[T].
+type ITypedDispositionHandler[T any] interface {
+	HandleDelivery(typedMessage models.TypedMessage[T]) Disposition
+}
+
+//AdaptTypedMessageHandler wraps an ITypedMessageHandler so that it can be used wherever an IMessageHandler is expected.
+//		A message whose payload is not a T is never passed to the handler. The error describing why is returned instead.
+func AdaptTypedMessageHandler[T any](handler ITypedMessageHandler[T]) IMessageHandler {
+	return typedMessageHandlerAdapter[T]{handler: handler}
+}
+
+//AdaptTypedDispositionHandler wraps an ITypedDispositionHandler so that it can be used wherever an IDispositionHandler is expected.
+//		A message whose payload is not a T is never passed to the handler. It is dead-lettered instead, as handling it again would fail again.
+func AdaptTypedDispositionHandler[T any](handler ITypedDispositionHandler[T]) IDispositionHandler {
+	return typedDispositionHandlerAdapter[T]{handler: handler}
+}
+
+type typedMessageHandlerAdapter[T any] struct {
+	handler ITypedMessageHandler[T]
+}
+
+func (adapter typedMessageHandlerAdapter[T]) HandleMessage(distributedMessage models.DistributedMessage) error {
+	typedMessage, err := models.NewTypedMessage[T](distributedMessage)
+	if err != nil {
+		return err
+	}
+	return adapter.handler.HandleMessage(typedMessage)
+}
+
+type typedDispositionHandlerAdapter[T any] struct {
+	handler ITypedDispositionHandler[T]
+}
+
+func (adapter typedDispositionHandlerAdapter[T]) HandleDelivery(distributedMessage models.DistributedMessage) Disposition {
+	typedMessage, err := models.NewTypedMessage[T](distributedMessage)
+	if err != nil {
+		return DeadLetter(err.Error())
+	}
+	return adapter.handler.HandleDelivery(typedMessage)
+}
@@ -0,0 +1,44 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KrylixZA/GoRabbitMqBroker/models"
+)
+
+type stubTypedDispositionHandler struct {
+	handled *[]string
+}
+
+func (handler stubTypedDispositionHandler) HandleDelivery(typedMessage models.TypedMessage[string]) Disposition {
+	*handler.handled = append(*handler.handled, typedMessage.Data)
+	return Ack()
+}
+
+func TestAdaptTypedDispositionHandler_GivenPayloadOfType_ShouldPassTypedMessageToHandler(t *testing.T) {
+	// Arrange
+	var handled []string
+	handler := AdaptTypedDispositionHandler[string](stubTypedDispositionHandler{handled: &handled})
+
+	// Act
+	disposition := handler.HandleDelivery(models.DistributedMessage{Data: "hello"})
+
+	// Assert
+	assert.Equal(t, Ack(), disposition)
+	assert.Equal(t, []string{"hello"}, handled)
+}
+
+func TestAdaptTypedDispositionHandler_GivenPayloadOfOtherType_ShouldDeadLetterWithoutCallingHandler(t *testing.T) {
+	// Arrange
+	var handled []string
+	handler := AdaptTypedDispositionHandler[string](stubTypedDispositionHandler{handled: &handled})
+
+	// Act
+	disposition := handler.HandleDelivery(models.DistributedMessage{Data: 42})
+
+	// Assert
+	assert.Equal(t, DeadLetterAction, disposition.Action)
+	assert.Empty(t, handled)
+}